@@ -0,0 +1,272 @@
+// Package cache keeps local copy of configuration loaded from remote sources
+package cache
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/crypt"
+	"github.com/go-mixins/loader/file"
+	"github.com/go-mixins/loader/libkv"
+)
+
+// DefaultRetryInterval is used when Config does not specify one
+var DefaultRetryInterval = 10 * time.Second
+
+// Config specifies the optional cache settings
+type Config struct {
+	// Key encrypts the cache file. If not set, the Key from environment is
+	// used, if any. The sources decrypt the values with that Key, so the
+	// decrypted values are never written to the file as plain text.
+	Key crypt.Key
+	// RetryInterval between attempts to reach the source while the cached
+	// configuration is served
+	RetryInterval time.Duration
+	// Decode puts the tree into the target, libkv.Decode if nil. The Decode
+	// method of libkv.Naming may be used for other key naming.
+	Decode func(tree, dest interface{}) error
+}
+
+// Loader wraps remote loader, persisting the last successfully loaded
+// configuration tree to the file. When the source is unavailable, the tree
+// is served from the file, and the source is retried in background. The
+// change is reported once the source recovers.
+//
+// The tree is loaded from the source as map[string]interface{} and decoded
+// into the target with Config.Decode, following the rules of libkv loader
+// with Lowercase naming by default, both from the source and from the cache.
+// Failure to persist the tree does not make the source unavailable, it is
+// reported to the handler set with WithWriteError.
+type Loader struct {
+	source  loader.Loader
+	path    string
+	cfg     Config
+	changes chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	retry   chan struct{}
+
+	lock      sync.Mutex
+	stale     bool
+	sourceErr error
+	onError   func(error)
+	closeOnce sync.Once
+}
+
+var _ loader.Loader = (*Loader)(nil)
+
+// New creates caching Loader for the source, storing the data in the named
+// file. The config may be nil.
+func New(source loader.Loader, path string, cfg *Config) *Loader {
+	res := &Loader{
+		source:  source,
+		path:    path,
+		changes: make(chan struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		retry:   make(chan struct{}, 1),
+	}
+	if cfg != nil {
+		res.cfg = *cfg
+	}
+	if res.cfg.RetryInterval <= 0 {
+		res.cfg.RetryInterval = DefaultRetryInterval
+	}
+	if res.cfg.Decode == nil {
+		res.cfg.Decode = libkv.Decode
+	}
+	go res.run()
+	return res
+}
+
+// Stale reports whether the last Load was served from the cache, and the
+// error of the source
+func (l *Loader) Stale() (bool, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.stale, l.sourceErr
+}
+
+// Load loads the tree from the source, falling back to the cache file
+func (l *Loader) Load(dest interface{}) error {
+	tree, err := l.fetch()
+	if err == nil {
+		l.setStale(nil)
+		return l.cfg.Decode(tree, dest)
+	}
+	cached, cacheErr := l.read()
+	if cacheErr != nil {
+		return loader.Errors.Wrapf(err, "source unavailable, cache: %v", cacheErr)
+	}
+	l.setStale(err)
+	return l.cfg.Decode(cached, dest)
+}
+
+// WithWriteError sets the handler of cache file errors and returns the
+// Loader. The handler is called when the tree loaded from the source can't
+// be persisted.
+func (l *Loader) WithWriteError(handler func(error)) *Loader {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.onError = handler
+	return l
+}
+
+func (l *Loader) setStale(err error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.stale, l.sourceErr = err != nil, err
+	if err != nil {
+		select {
+		case l.retry <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// fetch loads the tree from the source and stores it in the cache. The
+// error is returned only if the source fails.
+func (l *Loader) fetch() (interface{}, error) {
+	var tree map[string]interface{}
+	if err := l.source.Load(&tree); err != nil {
+		return nil, err
+	}
+	res := file.Normalize(tree)
+	if err := l.write(res); err != nil {
+		l.writeError(err)
+	}
+	return res, nil
+}
+
+func (l *Loader) writeError(err error) {
+	l.lock.Lock()
+	handler := l.onError
+	l.lock.Unlock()
+	if handler != nil {
+		handler(err)
+	}
+}
+
+func (l *Loader) write(tree interface{}) error {
+	data, err := json.Marshal(tree)
+	if err != nil {
+		return loader.Errors.Wrap(err, "encoding cache")
+	}
+	if key := l.key(); key != nil {
+		enc, err := key.Encrypt(string(data))
+		if err != nil {
+			return err
+		}
+		data = []byte(enc)
+	}
+	// The file is replaced atomically, so that it's never read partially
+	// written
+	tmp, err := ioutil.TempFile(filepath.Dir(l.path), "."+filepath.Base(l.path))
+	if err != nil {
+		return loader.Errors.Wrap(err, "creating cache file")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return loader.Errors.Wrap(err, "writing cache file")
+	}
+	if err = tmp.Close(); err != nil {
+		return loader.Errors.Wrap(err, "writing cache file")
+	}
+	return loader.Errors.Wrap(os.Rename(tmp.Name(), l.path), "replacing cache file")
+}
+
+// key returns the Key of the cache file, or nil if the file is not
+// encrypted
+func (l *Loader) key() crypt.Key {
+	if l.cfg.Key != nil {
+		return l.cfg.Key
+	}
+	// Without the Key in environment the source fails to load encrypted
+	// values, so there are no secrets to protect
+	key, _ := crypt.EnvKey()
+	return key
+}
+
+func (l *Loader) read() (interface{}, error) {
+	data, err := ioutil.ReadFile(l.path)
+	if err != nil {
+		return nil, loader.Errors.Wrap(err, "reading cache file")
+	}
+	if crypt.IsEncrypted(string(data)) {
+		key := l.key()
+		if key == nil {
+			return nil, loader.Errors.New("cache file is encrypted")
+		}
+		plain, err := key.Decrypt(string(data))
+		if err != nil {
+			return nil, err
+		}
+		data = []byte(plain.(string))
+	}
+	var res interface{}
+	return res, loader.Errors.Wrap(json.Unmarshal(data, &res), "decoding cache file")
+}
+
+// run forwards the source changes and retries the source while the cache
+// is served
+func (l *Loader) run() {
+	defer close(l.done)
+	defer close(l.changes)
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-l.retry:
+			retry = time.After(l.cfg.RetryInterval)
+			continue
+		case <-retry:
+			if stale, _ := l.Stale(); !stale {
+				retry = nil
+				continue
+			}
+			if _, err := l.fetch(); err != nil {
+				retry = time.After(l.cfg.RetryInterval)
+				continue
+			}
+			retry = nil
+		case _, ok := <-l.source.Changes():
+			if !ok {
+				// the source never reports changes anymore
+				<-l.stop
+				return
+			}
+		}
+		select {
+		case l.changes <- struct{}{}:
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// Changes provides the changes of the source. The change is also reported
+// when the source recovers after the cached data has been served.
+func (l *Loader) Changes() <-chan struct{} {
+	return l.changes
+}
+
+// Close stops the background process and closes the source, if it
+// implements io.Closer
+func (l *Loader) Close() (err error) {
+	l.closeOnce.Do(func() {
+		close(l.stop)
+		<-l.done
+		if c, ok := l.source.(io.Closer); ok {
+			err = c.Close()
+		}
+	})
+	return
+}
@@ -0,0 +1,189 @@
+package cache_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/cache"
+	"github.com/go-mixins/loader/crypt"
+	"github.com/go-mixins/loader/libkv"
+	"github.com/go-mixins/loader/loadertest"
+)
+
+type testConfig struct {
+	Name string
+	DB   struct {
+		Port int
+	}
+}
+
+func tempDir(t *testing.T) string {
+	td, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(td) })
+	return td
+}
+
+func TestLoader(t *testing.T) {
+	path := filepath.Join(tempDir(t), "config.json")
+	key, _ := crypt.NewKey()
+	cfg := &cache.Config{Key: key, RetryInterval: 20 * time.Millisecond}
+	source := loadertest.NewFake("name: x\ndb:\n  port: 5432\n")
+	l := cache.New(source, path, cfg)
+	var dest testConfig
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if stale, _ := l.Stale(); stale || dest.Name != "x" || dest.DB.Port != 5432 {
+		t.Errorf("invalid result %+v", dest)
+	}
+	l.Close()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !crypt.IsEncrypted(string(data)) {
+		t.Errorf("cache must be encrypted: %s", data)
+	}
+
+	// The service restarts while the source is unavailable
+	source = loadertest.NewFake("name: new\ndb:\n  port: 5433\n")
+	source.FailNext(1, errors.New("connection refused"))
+	l = cache.New(source, path, cfg)
+	defer l.Close()
+	dest = testConfig{}
+	if err = l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	stale, sourceErr := l.Stale()
+	if !stale || !loader.Errors.Contains(sourceErr) || dest.Name != "x" {
+		t.Errorf("expecting stale result, got %+v, %v", dest, sourceErr)
+	}
+	select {
+	case <-l.Changes():
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for recovery")
+	}
+	if err = l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if stale, _ = l.Stale(); stale || dest.Name != "new" {
+		t.Errorf("invalid result %+v", dest)
+	}
+
+	// The cache can't be used without the key
+	source.FailNext(1, errors.New("connection refused"))
+	noKey := cache.New(source, path, nil)
+	defer noKey.Close()
+	if err = noKey.Load(&dest); err == nil {
+		t.Error("expecting error")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	loadertest.Run(t, func(t *testing.T) loadertest.Subject {
+		path := filepath.Join(tempDir(t), "config.json")
+		source := loadertest.NewFake(nil)
+		return loadertest.Subject{
+			Store: func(src interface{}) error {
+				data, err := yaml.Marshal(src)
+				if err != nil {
+					return err
+				}
+				return source.Replace(string(data))
+			},
+			New:   func() loader.Loader { return cache.New(source, path, nil) },
+			Watch: true,
+		}
+	})
+}
+
+func TestLoader_WriteError(t *testing.T) {
+	path := filepath.Join(tempDir(t), "missing", "config.json")
+	l := cache.New(loadertest.NewFake("name: x\n"), path, nil)
+	defer l.Close()
+	var writeErr error
+	l.WithWriteError(func(err error) { writeErr = err })
+	var dest testConfig
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if stale, _ := l.Stale(); stale || dest.Name != "x" {
+		t.Errorf("invalid result %+v", dest)
+	}
+	if !loader.Errors.Contains(writeErr) {
+		t.Errorf("expecting write error, got %v", writeErr)
+	}
+}
+
+func TestLoader_Decode(t *testing.T) {
+	path := filepath.Join(tempDir(t), "config.json")
+	source := loadertest.NewFake("max_conns: 5\n")
+	cfg := &cache.Config{Decode: libkv.SnakeCase.Decode}
+	var dest struct {
+		MaxConns int
+	}
+	for _, fail := range []bool{false, true} {
+		if fail {
+			source.FailNext(1, errors.New("connection refused"))
+		}
+		l := cache.New(source, path, cfg)
+		err := l.Load(&dest)
+		l.Close()
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if dest.MaxConns != 5 {
+			t.Errorf("invalid result %+v", dest)
+		}
+		dest.MaxConns = 0
+	}
+}
+
+func TestLoader_EnvKey(t *testing.T) {
+	path := filepath.Join(tempDir(t), "config.json")
+	key, _ := crypt.NewKey()
+	if old, ok := os.LookupEnv(crypt.KeyEnv); ok {
+		defer os.Setenv(crypt.KeyEnv, old)
+	} else {
+		defer os.Unsetenv(crypt.KeyEnv)
+	}
+	os.Setenv(crypt.KeyEnv, key.String())
+	secret, err := key.Encrypt("secret")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	source := loadertest.NewFake(map[string]interface{}{"name": secret})
+	var dest testConfig
+	for _, fail := range []bool{false, true} {
+		if fail {
+			source.FailNext(1, errors.New("connection refused"))
+		}
+		l := cache.New(source, path, nil)
+		err := l.Load(&dest)
+		l.Close()
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if dest.Name != "secret" {
+			t.Errorf("invalid result %+v", dest)
+		}
+		dest.Name = ""
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !crypt.IsEncrypted(string(data)) {
+		t.Errorf("cache must be encrypted: %s", data)
+	}
+}
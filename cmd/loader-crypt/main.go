@@ -0,0 +1,183 @@
+// Command loader-crypt manages encrypted values in YAML configuration files.
+//
+// Usage:
+//
+//	loader-crypt keygen
+//	loader-crypt encrypt [-key-file NAME] [-match REGEXP] FILE...
+//	loader-crypt decrypt [-key-file NAME] FILE...
+//	loader-crypt rotate [-key-file NAME] -new-key-file NAME FILE...
+//
+// The key is taken from -key-file or from the environment, see package crypt.
+// Files are rewritten in place, comments are not preserved.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/go-mixins/loader/crypt"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "keygen":
+		err = keygen()
+	case "encrypt", "decrypt", "rotate":
+		err = process(cmd, args)
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: loader-crypt keygen|encrypt|decrypt|rotate [flags] FILE...")
+	os.Exit(2)
+}
+
+func keygen() error {
+	key, err := crypt.NewKey()
+	if err != nil {
+		return err
+	}
+	fmt.Println(key)
+	return nil
+}
+
+func process(cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	keyFile := fs.String("key-file", "", "key file name (default is taken from environment)")
+	newKeyFile := fs.String("new-key-file", "", "new key file name for rotate")
+	match := fs.String("match", "", "regular expression matching slash-separated paths of the values to encrypt")
+	fs.Parse(args)
+	var (
+		key, newKey crypt.Key
+		err         error
+	)
+	if *keyFile != "" {
+		key, err = crypt.ReadKey(*keyFile)
+	} else {
+		key, err = crypt.EnvKey()
+	}
+	if err != nil {
+		return err
+	}
+	re, err := regexp.Compile(*match)
+	if err != nil {
+		return err
+	}
+	var f func(path []string, val interface{}) (interface{}, error)
+	switch cmd {
+	case "encrypt":
+		f = func(path []string, val interface{}) (interface{}, error) {
+			if val == nil || crypt.IsEncrypted(val) || !re.MatchString(strings.Join(path, "/")) {
+				return val, nil
+			}
+			return key.Encrypt(val)
+		}
+	case "decrypt":
+		f = func(path []string, val interface{}) (interface{}, error) {
+			if !crypt.IsEncrypted(val) {
+				return val, nil
+			}
+			return key.Decrypt(val.(string))
+		}
+	case "rotate":
+		if *newKeyFile == "" {
+			return fmt.Errorf("-new-key-file is required")
+		}
+		if newKey, err = crypt.ReadKey(*newKeyFile); err != nil {
+			return err
+		}
+		f = func(path []string, val interface{}) (interface{}, error) {
+			if !crypt.IsEncrypted(val) {
+				return val, nil
+			}
+			plain, err := key.Decrypt(val.(string))
+			if err != nil {
+				return nil, err
+			}
+			return newKey.Encrypt(plain)
+		}
+	}
+	for _, name := range fs.Args() {
+		if err = rewrite(name, f); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// rewrite applies f to every scalar in the YAML file, replacing it in place
+func rewrite(name string, f func([]string, interface{}) (interface{}, error)) error {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var doc yaml.MapSlice
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	res, err := walk(nil, doc, f)
+	if err != nil {
+		return err
+	}
+	if data, err = yaml.Marshal(res); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Chmod(fi.Mode())
+	}
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func walk(path []string, node interface{}, f func([]string, interface{}) (interface{}, error)) (interface{}, error) {
+	var err error
+	switch v := node.(type) {
+	case yaml.MapSlice:
+		for i := range v {
+			if v[i].Value, err = walk(append(path, fmt.Sprint(v[i].Key)), v[i].Value, f); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case []interface{}:
+		for i := range v {
+			if v[i], err = walk(append(path, strconv.Itoa(i)), v[i], f); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	}
+	return f(path, node)
+}
@@ -0,0 +1,246 @@
+// Package crypt implements encrypted configuration values in the format
+// borrowed from Mozilla SOPS:
+//
+//	ENC[AES256_GCM,data:<base64>,iv:<base64>,tag:<base64>,type:<str|int|float|bool>]
+//
+// The values are decrypted by the loaders with the Key taken from environment.
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-mixins/loader"
+)
+
+// Errors defines error class for the decryption errors. They belong to
+// loader.DecodeErrors, since loading again does not fix them.
+var Errors = loader.DecodeErrors.Sub("crypt")
+
+var (
+	// KeyEnv names the environment variable containing the Key
+	KeyEnv = "CONFIG_LOADER_KEY"
+	// KeyFileEnv names the environment variable containing the name of Key file
+	KeyFileEnv = "CONFIG_LOADER_KEY_FILE"
+)
+
+const (
+	prefix  = "ENC[AES256_GCM,"
+	tagSize = 16
+)
+
+var encrypted = regexp.MustCompile(`^ENC\[AES256_GCM,data:([A-Za-z0-9+/=]*),iv:([A-Za-z0-9+/=]+),tag:([A-Za-z0-9+/=]+),type:(str|int|float|bool)\]$`)
+
+// Key is 256 bit AES key
+type Key []byte
+
+// NewKey generates random Key
+func NewKey() (Key, error) {
+	res := make(Key, 32)
+	if _, err := io.ReadFull(rand.Reader, res); err != nil {
+		return nil, Errors.Wrap(err, "generating key")
+	}
+	return res, nil
+}
+
+// ParseKey decodes base64 or hex encoded Key
+func ParseKey(s string) (Key, error) {
+	s = strings.TrimSpace(s)
+	res, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		if res, err = hex.DecodeString(s); err != nil {
+			return nil, Errors.New("key must be base64 or hex encoded")
+		}
+	}
+	if len(res) != 32 {
+		return nil, Errors.Errorf("key must be 32 bytes long, got %d", len(res))
+	}
+	return res, nil
+}
+
+// ReadKey reads Key from file
+func ReadKey(name string) (Key, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, Errors.Wrap(err, "reading key file")
+	}
+	return ParseKey(string(data))
+}
+
+// EnvKey returns the Key from the environment variable named by KeyEnv or,
+// if it is empty, from the file named by KeyFileEnv
+func EnvKey() (Key, error) {
+	if s := os.Getenv(KeyEnv); s != "" {
+		return ParseKey(s)
+	}
+	if name := os.Getenv(KeyFileEnv); name != "" {
+		return ReadKey(name)
+	}
+	return nil, Errors.Errorf("no key: neither %s nor %s is set", KeyEnv, KeyFileEnv)
+}
+
+// String encodes the Key in base64
+func (k Key) String() string {
+	return base64.StdEncoding.EncodeToString(k)
+}
+
+func (k Key) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, Errors.Wrap(err, "creating cipher")
+	}
+	res, err := cipher.NewGCM(block)
+	return res, Errors.Wrap(err, "creating GCM")
+}
+
+// IsEncrypted checks if the value is an encrypted string
+func IsEncrypted(val interface{}) bool {
+	s, ok := val.(string)
+	return ok && strings.HasPrefix(s, prefix)
+}
+
+// Contains quickly checks if the data could contain encrypted values
+func Contains(data []byte) bool {
+	return bytes.Contains(data, []byte(prefix))
+}
+
+// Encrypt returns encrypted representation of scalar value
+func (k Key) Encrypt(val interface{}) (string, error) {
+	var typ, plain string
+	switch v := val.(type) {
+	case string:
+		typ, plain = "str", v
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		typ, plain = "int", fmt.Sprint(v)
+	case float32, float64:
+		typ, plain = "float", fmt.Sprint(v)
+	case bool:
+		typ, plain = "bool", strconv.FormatBool(v)
+	default:
+		return "", Errors.Errorf("can't encrypt value of type %T", val)
+	}
+	aead, err := k.aead()
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, iv); err != nil {
+		return "", Errors.Wrap(err, "generating IV")
+	}
+	sealed := aead.Seal(nil, iv, []byte(plain), nil)
+	enc := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("%sdata:%s,iv:%s,tag:%s,type:%s]", prefix,
+		enc(sealed[:len(sealed)-tagSize]), enc(iv), enc(sealed[len(sealed)-tagSize:]), typ), nil
+}
+
+// Decrypt returns decrypted value of the type it had been encrypted with
+func (k Key) Decrypt(s string) (interface{}, error) {
+	m := encrypted.FindStringSubmatch(s)
+	if m == nil {
+		return nil, Errors.New("invalid encrypted value format")
+	}
+	var parts [3][]byte
+	for i := range parts {
+		var err error
+		if parts[i], err = base64.StdEncoding.DecodeString(m[i+1]); err != nil {
+			return nil, Errors.Wrap(err, "decoding encrypted value")
+		}
+	}
+	aead, err := k.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(parts[1]) != aead.NonceSize() {
+		return nil, Errors.Errorf("invalid IV size %d", len(parts[1]))
+	}
+	plain, err := aead.Open(nil, parts[1], append(parts[0], parts[2]...), nil)
+	if err != nil {
+		return nil, Errors.Wrap(err, "decrypting value")
+	}
+	var res interface{}
+	switch m[4] {
+	case "int":
+		res, err = strconv.ParseInt(string(plain), 10, 64)
+	case "float":
+		res, err = strconv.ParseFloat(string(plain), 64)
+	case "bool":
+		res, err = strconv.ParseBool(string(plain))
+	default:
+		res = string(plain)
+	}
+	return res, Errors.Wrapf(err, "parsing decrypted %s", m[4])
+}
+
+// Walk calls f for each scalar in the tree of maps and slices, replacing the
+// values with the results. The path to the value is passed as well.
+func Walk(tree interface{}, f func(path []string, val interface{}) (interface{}, error)) (interface{}, error) {
+	return walk(nil, tree, f)
+}
+
+func walk(path []string, node interface{}, f func([]string, interface{}) (interface{}, error)) (interface{}, error) {
+	var err error
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for k := range v {
+			if v[k], err = walk(append(path, k), v[k], f); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case map[interface{}]interface{}:
+		for k := range v {
+			if v[k], err = walk(append(path, fmt.Sprint(k)), v[k], f); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case []interface{}:
+		for i := range v {
+			if v[i], err = walk(append(path, strconv.Itoa(i)), v[i], f); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	}
+	return f(path, node)
+}
+
+// DecryptTree replaces all encrypted values in the tree with decrypted ones
+func (k Key) DecryptTree(tree interface{}) (interface{}, error) {
+	return Walk(tree, func(path []string, val interface{}) (interface{}, error) {
+		if !IsEncrypted(val) {
+			return val, nil
+		}
+		res, err := k.Decrypt(val.(string))
+		return res, Errors.Wrapf(err, "at %q", strings.Join(path, "/"))
+	})
+}
+
+// DecryptTree decrypts the tree with the Key from environment. The Key is not
+// required unless the tree contains encrypted values.
+func DecryptTree(tree interface{}) (interface{}, error) {
+	found := false
+	Walk(tree, func(_ []string, val interface{}) (interface{}, error) {
+		found = found || IsEncrypted(val)
+		return val, nil
+	})
+	if !found {
+		return tree, nil
+	}
+	key, err := EnvKey()
+	if err != nil {
+		return nil, err
+	}
+	return key.DecryptTree(tree)
+}
@@ -0,0 +1,91 @@
+package crypt_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/go-mixins/loader/crypt"
+	"github.com/go-mixins/loader/file"
+)
+
+// setenv sets the environment variable and returns the function restoring
+// its previous state
+func setenv(name, value string) func() {
+	old, ok := os.LookupEnv(name)
+	os.Setenv(name, value)
+	return func() {
+		if ok {
+			os.Setenv(name, old)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func TestKey_Encrypt(t *testing.T) {
+	key, err := crypt.NewKey()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	other, _ := crypt.NewKey()
+	for _, val := range []interface{}{"secret", "", int64(42), 0.5, true} {
+		enc, err := key.Encrypt(val)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if !crypt.IsEncrypted(enc) {
+			t.Errorf("%q must be encrypted", enc)
+		}
+		res, err := key.Decrypt(enc)
+		if err != nil {
+			t.Errorf("%+v", err)
+		} else if res != val {
+			t.Errorf("expected %#v, got %#v", val, res)
+		}
+		if _, err = other.Decrypt(enc); !crypt.Errors.Contains(err) {
+			t.Errorf("decrypting with wrong key must fail with crypt error, got %+v", err)
+		}
+	}
+	if _, err = crypt.ParseKey("short"); err == nil {
+		t.Error("invalid key must fail")
+	}
+	if _, err = crypt.ParseKey(key.String()); err != nil {
+		t.Errorf("%+v", err)
+	}
+}
+
+func TestDecryptTree(t *testing.T) {
+	key, _ := crypt.NewKey()
+	password, _ := key.Encrypt("secret")
+	port, _ := key.Encrypt(5432)
+	f, err := ioutil.TempFile("", "loader")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("db:\n  user: app\n  password: " + password + "\n  port: " + port + "\n")
+	f.Close()
+	var dest struct {
+		DB struct {
+			User     string
+			Password string
+			Port     int
+		}
+	}
+	l := file.YAML(f.Name())
+	defer l.Close()
+	defer setenv(crypt.KeyFileEnv, "")()
+	restore := setenv(crypt.KeyEnv, "")
+	defer restore()
+	if err = l.Load(&dest); !crypt.Errors.Contains(err) {
+		t.Errorf("expecting crypt error without key, got %+v", err)
+	}
+	os.Setenv(crypt.KeyEnv, key.String())
+	if err = l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dest.DB.User != "app" || dest.DB.Password != "secret" || dest.DB.Port != 5432 {
+		t.Errorf("invalid result %+v", dest)
+	}
+}
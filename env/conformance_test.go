@@ -0,0 +1,88 @@
+package env_test
+
+import (
+	"encoding"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/env"
+	"github.com/go-mixins/loader/loadertest"
+)
+
+// formatValue is the inverse of envconfig value parsing
+func formatValue(v reflect.Value) string {
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		text, _ := m.MarshalText()
+		return string(text)
+	}
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	switch v.Kind() {
+	case reflect.Slice:
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = formatValue(v.Index(i))
+		}
+		return strings.Join(items, ",")
+	case reflect.Map:
+		var items []string
+		for _, k := range v.MapKeys() {
+			items = append(items, formatValue(k)+":"+formatValue(v.MapIndex(k)))
+		}
+		sort.Strings(items)
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// dotenv renders the object as dotenv file
+func dotenv(prefix string, src interface{}) (string, error) {
+	vars, err := env.Vars(prefix, src)
+	if err != nil {
+		return "", err
+	}
+	var res strings.Builder
+	for _, v := range vars {
+		val := reflect.ValueOf(src).Elem()
+		for _, f := range v.Path {
+			val = val.FieldByIndex(f.Index)
+		}
+		fmt.Fprintf(&res, "%s=%s\n", v.Key, strconv.Quote(formatValue(val)))
+	}
+	return res.String(), nil
+}
+
+func TestConformance(t *testing.T) {
+	defer func(timeout int) { env.DebounceTimeout = timeout }(env.DebounceTimeout)
+	env.DebounceTimeout = 100
+	loadertest.Run(t, func(t *testing.T) loadertest.Subject {
+		td, err := ioutil.TempDir("", "loader")
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		t.Cleanup(func() { os.RemoveAll(td) })
+		name := filepath.Join(td, ".env")
+		return loadertest.Subject{
+			Store: func(src interface{}) error {
+				data, err := dotenv("app", src)
+				if err != nil {
+					return err
+				}
+				return ioutil.WriteFile(name, []byte(data), 0644)
+			},
+			New:      func() loader.Loader { return env.Dotenv("app", name) },
+			Watch:    true,
+			Debounce: true,
+		}
+	})
+}
@@ -1,42 +1,157 @@
 package env
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 
-	"github.com/kelseyhightower/envconfig"
-
 	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/internal/watch"
 )
 
+// DebounceTimeout defines secret file change event settle time in
+// milliseconds
+var DebounceTimeout = 500
+
+// FileSuffix marks the variable containing the name of the file to read the
+// value from, like APP_DB_PASSWORD_FILE=/run/secrets/db
+const FileSuffix = "_FILE"
+
 // Loader implements loader.Loader
 type Loader struct {
 	prefix  string
-	changes chan struct{}
+	naming  Naming
+	unknown UnknownHandler
+	sources chain
+	dotenv  string
+	watcher *watch.Watcher
+	err     error
 }
 
 var _ loader.Loader = (*Loader)(nil)
 
-// Load loads the target from environment
+// Load loads the target from environment. The values of variables having
+// FileSuffix are read from the named files, which are put under watch.
 func (l *Loader) Load(dest interface{}) error {
-	return loader.Errors.Wrap(envconfig.Process(l.prefix, dest), "load from environment")
+	if l.err != nil {
+		return l.err
+	}
+	src, err := l.source()
+	if err != nil {
+		return err
+	}
+	var (
+		files []string
+		keys  = src.Keys()
+		known = make(map[string]bool)
+	)
+	err = process(l.naming, l.prefix, dest, keys, func(key string) (string, bool, error) {
+		known[key] = true
+		value, ok := src.Lookup(key)
+		name, isFile := src.Lookup(key + FileSuffix)
+		if !isFile {
+			return value, ok, nil
+		}
+		if ok {
+			return "", false, loader.DecodeErrors.Errorf("both %s and %s%s are set", key, key, FileSuffix)
+		}
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return "", false, loader.Errors.Wrapf(err, "reading %s%s", key, FileSuffix)
+		}
+		files = append(files, filepath.Dir(name))
+		return strings.TrimRight(string(data), "\r\n"), true, nil
+	})
+	if loader.DecodeErrors.Contains(err) {
+		// the class is kept, so that the error is not retried
+		return loader.DecodeErrors.Wrap(err, "load from environment")
+	}
+	if err != nil {
+		return loader.Errors.Wrap(err, "load from environment")
+	}
+	if l.unknown != nil && l.prefix != "" {
+		if unknown := findUnknown(l.prefix+l.naming.Separator, keys, known); len(unknown) > 0 {
+			if err = l.unknown(unknown); err != nil {
+				return err
+			}
+		}
+	}
+	// The directories are watched rather than the files, since the mounted
+	// secrets are usually replaced by swapping symlinks
+	return l.watcher.SetExtra(files)
 }
 
-// Close closes underlying changes channel
+func (l *Loader) source() (chain, error) {
+	if l.dotenv == "" {
+		return l.sources, nil
+	}
+	f, err := os.Open(l.dotenv)
+	if err != nil {
+		return nil, loader.Errors.Wrap(err, "opening dotenv file")
+	}
+	defer f.Close()
+	vars, err := ParseDotenv(f)
+	if err != nil {
+		return nil, loader.Errors.Wrapf(err, "parsing %q", l.dotenv)
+	}
+	return chain{Map(vars)}, nil
+}
+
+// Close stops watching secret files and closes underlying changes channel
 func (l *Loader) Close() error {
-	close(l.changes)
-	return nil
+	return l.watcher.Close()
 }
 
-// Changes provides source of config change events. For environment variables
-// there will never be any.
+// Changes provides source of config change events. The changes of the
+// variables themselves are never reported, only the changes of dotenv file
+// and the files referenced with FileSuffix variables.
 func (l *Loader) Changes() <-chan struct{} {
-	return l.changes
+	return l.watcher.Changes()
 }
 
-// New creates loader initialized with environment variable prefix
-func New(prefix string) *Loader {
-	return &Loader{
+// New creates loader initialized with environment variable prefix. The
+// variables are looked up in the process environment, unless the sources are
+// specified. The earlier sources take precedence over the later ones.
+func New(prefix string, sources ...Source) *Loader {
+	if len(sources) == 0 {
+		sources = []Source{OS}
+	}
+	res := &Loader{
 		prefix:  strings.ToUpper(prefix),
-		changes: make(chan struct{}),
+		naming:  Envconfig,
+		sources: sources,
+	}
+	// The watcher is usable even in case of error, it just never reports
+	// any changes
+	res.watcher, _ = watch.New(&DebounceTimeout, func(string) bool { return false })
+	return res
+}
+
+// Dotenv creates loader reading the variables from dotenv file. The file is
+// read on each Load and is watched for changes. If the directory of the file
+// can't be watched, Load returns the error.
+func Dotenv(prefix, name string) *Loader {
+	res := &Loader{
+		prefix: strings.ToUpper(prefix),
+		naming: Envconfig,
+		dotenv: name,
 	}
+	// The directory is watched to keep track of the file being replaced
+	name = filepath.Clean(name)
+	res.watcher, _ = watch.New(&DebounceTimeout, func(ev string) bool { return ev == name })
+	res.err = res.watcher.Add(filepath.Dir(name))
+	return res
+}
+
+// WithNaming sets the naming of variables and returns the Loader
+func (l *Loader) WithNaming(naming Naming) *Loader {
+	l.naming = naming
+	return l
+}
+
+// WithUnknown sets the handler of unknown variables and returns the Loader
+func (l *Loader) WithUnknown(handler UnknownHandler) *Loader {
+	l.unknown = handler
+	return l
 }
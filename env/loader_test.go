@@ -0,0 +1,309 @@
+package env_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/kelseyhightower/envconfig"
+
+	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/env"
+)
+
+type Embedded struct {
+	Level string `default:"info"`
+}
+
+type testConfig struct {
+	Embedded
+	DatabasePassword string `split_words:"true"`
+	Port             int    `envconfig:"HTTP_PORT"`
+	Timeout          time.Duration
+	Tags             []string
+	Limits           map[string]int
+	Backend          *struct {
+		Host string
+	}
+	Ignored string `ignored:"true"`
+}
+
+func setenv(t *testing.T, vars map[string]string) func() {
+	for k, v := range vars {
+		if err := os.Setenv(k, v); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	return func() {
+		for k := range vars {
+			os.Unsetenv(k)
+		}
+	}
+}
+
+func TestLoader_Load(t *testing.T) {
+	defer setenv(t, map[string]string{
+		"TEST_DATABASE_PASSWORD": "secret",
+		"HTTP_PORT":              "8080",
+		"TEST_TIMEOUT":           "1s",
+		"TEST_TAGS":              "a,b",
+		"TEST_LIMITS":            "a:1,b:2",
+		"TEST_BACKEND_HOST":      "db",
+		"TEST_IGNORED":           "x",
+	})()
+	var expect, dest testConfig
+	if err := envconfig.Process("test", &expect); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	l := env.New("test")
+	defer l.Close()
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if diff := deep.Equal(expect, dest); diff != nil {
+		t.Errorf("%+v", diff)
+	}
+}
+
+func TestLoader_File(t *testing.T) {
+	td, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer os.RemoveAll(td)
+	secret := filepath.Join(td, "db")
+	if err = ioutil.WriteFile(secret, []byte("secret\n"), 0600); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer setenv(t, map[string]string{"TEST_DATABASE_PASSWORD_FILE": secret})()
+	var dest testConfig
+	l := env.New("test")
+	defer l.Close()
+	if err = l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dest.DatabasePassword != "secret" {
+		t.Errorf("invalid result %+v", dest)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		ioutil.WriteFile(secret, []byte("rotated\n"), 0600)
+	}()
+	select {
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for change")
+	case <-l.Changes():
+	}
+	defer setenv(t, map[string]string{"TEST_DATABASE_PASSWORD": "secret"})()
+	if err = l.Load(&dest); !loader.DecodeErrors.Contains(err) {
+		t.Errorf("expecting decode error when both variable and file are set, got %v", err)
+	}
+}
+
+func TestNew_Sources(t *testing.T) {
+	t.Parallel()
+	var dest testConfig
+	l := env.New("app",
+		env.Map(map[string]string{"APP_TAGS": "a,b"}),
+		env.Environ([]string{"APP_TAGS=c", "HTTP_PORT=80", "APP_BACKEND_HOST=a=b"}),
+	)
+	defer l.Close()
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if diff := deep.Equal([]string{"a", "b"}, dest.Tags); diff != nil {
+		t.Errorf("%+v", diff)
+	}
+	if dest.Port != 80 || dest.Backend.Host != "a=b" {
+		t.Errorf("invalid result %+v", dest)
+	}
+}
+
+func TestDotenv(t *testing.T) {
+	t.Parallel()
+	td, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer os.RemoveAll(td)
+	name := filepath.Join(td, ".env")
+	data := `# comment
+export APP_DATABASE_PASSWORD="p\"a#ss\n"
+HTTP_PORT=8080 # comment
+
+APP_TAGS='a,b # c'
+APP_TIMEOUT = 1m
+`
+	if err = ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	l := env.Dotenv("app", name)
+	defer l.Close()
+	var dest testConfig
+	if err = l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dest.DatabasePassword != "p\"a#ss\n" || dest.Port != 8080 || dest.Timeout != time.Minute {
+		t.Errorf("invalid result %+v", dest)
+	}
+	if diff := deep.Equal([]string{"a", "b # c"}, dest.Tags); diff != nil {
+		t.Errorf("%+v", diff)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		ioutil.WriteFile(name, []byte("invalid"), 0644)
+	}()
+	select {
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for change")
+	case <-l.Changes():
+	}
+	if err = l.Load(&dest); err == nil {
+		t.Error("expecting error for invalid file")
+	}
+	missing := env.Dotenv("app", filepath.Join(td, "missing", ".env"))
+	defer missing.Close()
+	if err = missing.Load(&dest); err == nil || !strings.Contains(err.Error(), "watcher") {
+		t.Errorf("expecting watcher error, got %+v", err)
+	}
+}
+
+func TestLoader_WithNaming(t *testing.T) {
+	t.Parallel()
+	type config struct {
+		DBMaxConns int
+		HTTPServer struct {
+			ReadTimeout time.Duration `yaml:"read_timeout"`
+		}
+		Alias string `envconfig:"ALIAS"`
+	}
+	for _, tc := range []struct {
+		naming env.Naming
+		vars   map[string]string
+	}{
+		{env.Envconfig, map[string]string{
+			"APP_DBMAXCONNS":             "5",
+			"APP_HTTPSERVER_READTIMEOUT": "1s",
+			"ALIAS":                      "x",
+		}},
+		{env.SnakeCase, map[string]string{
+			"APP_DB_MAX_CONNS":             "5",
+			"APP_HTTP_SERVER_READ_TIMEOUT": "1s",
+			"APP_ALIAS":                    "x",
+		}},
+		{env.DoubleUnderscore, map[string]string{
+			"APP__DBMAXCONNS":               "5",
+			"APP__HTTPSERVER__READ_TIMEOUT": "1s",
+			"APP__ALIAS":                    "x",
+		}},
+		{env.DoubleUnderscoreSnakeCase, map[string]string{
+			"APP__DB_MAX_CONNS":              "5",
+			"APP__HTTP_SERVER__READ_TIMEOUT": "1s",
+			"APP__ALIAS":                     "x",
+		}},
+	} {
+		var dest config
+		l := env.New("app", env.Map(tc.vars)).WithNaming(tc.naming)
+		if err := l.Load(&dest); err != nil {
+			t.Errorf("%+v", err)
+		}
+		l.Close()
+		if dest.DBMaxConns != 5 || dest.HTTPServer.ReadTimeout != time.Second || dest.Alias != "x" {
+			t.Errorf("%+v: invalid result %+v", tc.vars, dest)
+		}
+	}
+}
+
+func TestLoader_Indexed(t *testing.T) {
+	t.Parallel()
+	type backend struct {
+		Host  string
+		Ports []int
+	}
+	type upstream struct {
+		URL     string
+		Retries int `default:"3"`
+	}
+	type config struct {
+		Backends  []backend
+		Upstreams map[string]*upstream
+		Tags      []string
+	}
+	var dest config
+	l := env.New("app", env.Map{
+		"APP_BACKENDS_2_HOST":           "b",
+		"APP_BACKENDS_10_HOST":          "c",
+		"APP_BACKENDS_1_HOST":           "a",
+		"APP_BACKENDS_1_PORTS":          "80,443",
+		"APP_UPSTREAMS_auth_URL":        "http://auth",
+		"APP_UPSTREAMS_billing_URL":     "http://billing",
+		"APP_UPSTREAMS_billing_RETRIES": "5",
+		"APP_TAGS":                      "a,b",
+	})
+	defer l.Close()
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := config{
+		Backends: []backend{{"a", []int{80, 443}}, {"b", nil}, {"c", nil}},
+		Upstreams: map[string]*upstream{
+			"auth":    {URL: "http://auth", Retries: 3},
+			"billing": {URL: "http://billing", Retries: 5},
+		},
+		Tags: []string{"a", "b"},
+	}
+	if diff := deep.Equal(expect, dest); diff != nil {
+		t.Errorf("%+v", diff)
+	}
+	l = env.New("app", env.Map{"APP_BACKENDS_X_HOST": "a"})
+	defer l.Close()
+	if err := l.Load(&dest); !loader.DecodeErrors.Contains(err) {
+		t.Errorf("expecting decode error for invalid index, got %v", err)
+	}
+}
+
+func TestLoader_WithUnknown(t *testing.T) {
+	t.Parallel()
+	type config struct {
+		DatabaseURL string `split_words:"true"`
+		Backends    []struct {
+			Host string
+		}
+		Password string
+	}
+	vars := env.Map{
+		"APP_DATABSE_URL":      "postgres://",
+		"APP_BACKENDS_0_HOST":  "a",
+		"APP_BACKENDS_0_PORT":  "80",
+		"APP_PASSWROD_FILE":    "/run/secrets/db",
+		"APP_COMPLETELY_OTHER": "x",
+		"OTHER_VAR":            "y",
+	}
+	var got []env.UnknownVar
+	l := env.New("app", vars).WithUnknown(func(vars []env.UnknownVar) error {
+		got = vars
+		return nil
+	})
+	defer l.Close()
+	var dest config
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := []env.UnknownVar{
+		{Key: "APP_BACKENDS_0_PORT", Suggestion: "APP_BACKENDS_0_HOST"},
+		{Key: "APP_COMPLETELY_OTHER"},
+		{Key: "APP_DATABSE_URL", Suggestion: "APP_DATABASE_URL"},
+		{Key: "APP_PASSWROD_FILE", Suggestion: "APP_PASSWORD_FILE"},
+	}
+	if diff := deep.Equal(expect, got); diff != nil {
+		t.Errorf("%+v", diff)
+	}
+	l.WithUnknown(env.RejectUnknown)
+	if err := l.Load(&dest); err == nil {
+		t.Error("expecting error for unknown variables")
+	}
+}
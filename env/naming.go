@@ -0,0 +1,104 @@
+package env
+
+import (
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// Naming defines how the variable names are derived from struct fields
+type Naming struct {
+	// Separator is put between the names of the nesting levels, including
+	// the prefix
+	Separator string
+	// Name returns the name of the field on its nesting level
+	Name func(field reflect.StructField) string
+}
+
+var (
+	// Envconfig naming follows the rules of
+	// github.com/kelseyhightower/envconfig, where APP_DB_HOST stands for
+	// DB.Host. The field name is split into words only if `split_words`
+	// tag is set.
+	Envconfig = Naming{Separator: "_", Name: envconfigName}
+	// SnakeCase naming splits every field name into words, so that
+	// APP_DB_MAX_CONNS stands for DB.MaxConns
+	SnakeCase = Naming{Separator: "_", Name: snakeName}
+	// DoubleUnderscore naming separates nesting levels with double
+	// underscore and names the fields by their YAML keys, so that
+	// APP__DB__MAXCONNS stands for db.maxconns key in YAML document, or
+	// APP__DB__MAX_CONNS for the field tagged `yaml:"max_conns"`
+	DoubleUnderscore = Naming{Separator: "__", Name: yamlName}
+	// DoubleUnderscoreSnakeCase naming is like DoubleUnderscore, but the
+	// fields without `yaml` tag are split into words, so that
+	// APP__DB__MAX_CONNS stands for DB.MaxConns
+	DoubleUnderscoreSnakeCase = Naming{Separator: "__", Name: yamlSnakeName}
+)
+
+// key returns the variable name for the field, upper-casing the field name
+func (n Naming) key(prefix string, field reflect.StructField) string {
+	res := strings.ToUpper(n.Name(field))
+	if prefix != "" {
+		res = prefix + n.Separator + res
+	}
+	return res
+}
+
+func envconfigName(field reflect.StructField) string {
+	if alt := field.Tag.Get("envconfig"); alt != "" {
+		return alt
+	}
+	if field.Tag.Get("split_words") == "true" {
+		if words := splitWords.FindAllString(field.Name, -1); len(words) > 0 {
+			return strings.Join(words, "_")
+		}
+	}
+	return field.Name
+}
+
+func snakeName(field reflect.StructField) string {
+	if alt := field.Tag.Get("envconfig"); alt != "" {
+		return alt
+	}
+	return snakeCase(field.Name)
+}
+
+// yamlName follows the rules of gopkg.in/yaml.v2 for naming the fields
+func yamlName(field reflect.StructField) string {
+	if alt := field.Tag.Get("envconfig"); alt != "" {
+		return alt
+	}
+	if name := strings.Split(field.Tag.Get("yaml"), ",")[0]; name != "" && name != "-" {
+		return name
+	}
+	return field.Name
+}
+
+// yamlSnakeName is like yamlName, but splits the field name into words
+func yamlSnakeName(field reflect.StructField) string {
+	if alt := field.Tag.Get("envconfig"); alt != "" {
+		return alt
+	}
+	if name := strings.Split(field.Tag.Get("yaml"), ",")[0]; name != "" && name != "-" {
+		return name
+	}
+	return snakeCase(field.Name)
+}
+
+// snakeCase splits the identifier into words, keeping acronyms together, so
+// that "DBMaxConns" becomes "DB_MAX_CONNS"
+func snakeCase(s string) string {
+	runes := []rune(s)
+	var res []rune
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				res = append(res, '_')
+			}
+		}
+		res = append(res, r)
+	}
+	return string(res)
+}
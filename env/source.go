@@ -0,0 +1,168 @@
+package env
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-mixins/loader"
+)
+
+// Source provides the values of variables
+type Source interface {
+	// Lookup retrieves the value of the variable named by the key. The
+	// boolean result reports if the variable is present.
+	Lookup(key string) (string, bool)
+}
+
+// Lister is implemented by the sources able to enumerate the variables they
+// contain. The indexed variables for slices and maps of structs are only
+// recognized in such sources.
+type Lister interface {
+	Keys() []string
+}
+
+// LookupFunc adapts lookup function like os.LookupEnv to Source
+type LookupFunc func(key string) (string, bool)
+
+// Lookup calls the function
+func (f LookupFunc) Lookup(key string) (string, bool) {
+	return f(key)
+}
+
+// OS is the process environment Source
+var OS Source = osSource{}
+
+type osSource struct{}
+
+func (osSource) Lookup(key string) (string, bool) {
+	return os.LookupEnv(key)
+}
+
+func (osSource) Keys() []string {
+	return Environ(os.Environ()).Keys()
+}
+
+// Map is Source backed by the map
+type Map map[string]string
+
+// Lookup returns the map value
+func (m Map) Lookup(key string) (res string, ok bool) {
+	res, ok = m[key]
+	return
+}
+
+// Keys returns the map keys
+func (m Map) Keys() []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Environ returns Source backed by the list of "key=value" strings, as
+// returned by os.Environ
+func Environ(environ []string) Map {
+	vars := make(Map, len(environ))
+	for _, kv := range environ {
+		if i := strings.Index(kv, "="); i >= 0 {
+			vars[kv[:i]] = kv[i+1:]
+		}
+	}
+	return vars
+}
+
+// chain is Source trying the sources in order
+type chain []Source
+
+func (c chain) Lookup(key string) (string, bool) {
+	for _, src := range c {
+		if res, ok := src.Lookup(key); ok {
+			return res, true
+		}
+	}
+	return "", false
+}
+
+func (c chain) Keys() []string {
+	seen := make(map[string]bool)
+	var res []string
+	for _, src := range c {
+		lister, ok := src.(Lister)
+		if !ok {
+			continue
+		}
+		for _, k := range lister.Keys() {
+			if !seen[k] {
+				seen[k] = true
+				res = append(res, k)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// ParseDotenv reads variables in dotenv format. Every line contains
+// KEY=VALUE assignment, optionally preceded with "export". The values may be
+// enclosed in single quotes, taken literally, or double quotes, supporting
+// escape sequences. Blank lines and the ones starting with # are ignored, as
+// well as the comments after unquoted values.
+func ParseDotenv(r io.Reader) (map[string]string, error) {
+	res := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return nil, loader.Errors.Errorf("line %d: expecting KEY=VALUE", n)
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		switch {
+		case strings.HasPrefix(value, `"`):
+			end := closingQuote(value)
+			if end < 0 {
+				return nil, loader.Errors.Errorf("line %d: unterminated quoted value", n)
+			}
+			s, err := strconv.Unquote(value[:end+1])
+			if err != nil {
+				return nil, loader.Errors.Wrapf(err, "line %d", n)
+			}
+			value = s
+		case strings.HasPrefix(value, "'"):
+			end := strings.Index(value[1:], "'")
+			if end < 0 {
+				return nil, loader.Errors.Errorf("line %d: unterminated quoted value", n)
+			}
+			value = value[1 : end+1]
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+		res[key] = value
+	}
+	return res, loader.Errors.Wrap(scanner.Err(), "reading dotenv")
+}
+
+// closingQuote returns the index of the double quote closing the value
+func closingQuote(value string) int {
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,404 @@
+package env
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+
+	"github.com/go-mixins/loader"
+)
+
+// The specification gathering and field processing are borrowed from the
+// package "github.com/kelseyhightower/envconfig" to keep its naming rules
+// and struct tags, while reading the values from arbitrary source.
+// Copyright (c) 2013 Kelsey Hightower.
+
+var splitWords = regexp.MustCompile("([^A-Z]+|[A-Z][^A-Z]+|[A-Z]+)")
+
+// varInfo maintains information about the configuration variable
+type varInfo struct {
+	Name  string
+	Alt   string
+	Key   string
+	Field reflect.Value
+	Tags  reflect.StructTag
+	Path  []reflect.StructField
+	// Indexed is set for slices and maps of structs, populated from
+	// variables like APP_BACKENDS_0_HOST
+	Indexed bool
+}
+
+// Var describes the environment variable mapped to a struct field
+type Var struct {
+	// Key is the variable name
+	Key string
+	// Alt is the alternative name set with envconfig tag
+	Alt string
+	// Path lists the struct fields leading to the value, starting from the
+	// top-level one
+	Path []reflect.StructField
+	// Indexed is set for slices and maps of structs. Their element fields
+	// are named after Key, index or map key and Separator of naming, like
+	// APP_BACKENDS_0_HOST.
+	Indexed bool
+}
+
+// Vars lists the variables the loader with the prefix and default naming
+// looks up for the spec
+func Vars(prefix string, spec interface{}) ([]Var, error) {
+	return Envconfig.Vars(prefix, spec)
+}
+
+// Vars lists the variables the loader with the prefix and the naming looks up
+// for the spec
+func (n Naming) Vars(prefix string, spec interface{}) ([]Var, error) {
+	return vars(n, strings.ToUpper(prefix), spec)
+}
+
+// Vars lists the variables the loader looks up for the spec
+func (l *Loader) Vars(spec interface{}) ([]Var, error) {
+	return vars(l.naming, l.prefix, spec)
+}
+
+func vars(naming Naming, prefix string, spec interface{}) ([]Var, error) {
+	infos, err := gatherInfo(naming, prefix, spec, nil)
+	if err != nil {
+		return nil, loader.Errors.Wrap(err, "gathering specification")
+	}
+	res := make([]Var, len(infos))
+	for i, info := range infos {
+		res[i] = Var{Key: info.Key, Alt: info.Alt, Path: info.Path, Indexed: info.Indexed}
+	}
+	return res, nil
+}
+
+// gatherInfo gathers information about the specified struct
+func gatherInfo(naming Naming, prefix string, spec interface{}, path []reflect.StructField) ([]varInfo, error) {
+	s := reflect.ValueOf(spec)
+	if s.Kind() != reflect.Ptr {
+		return nil, envconfig.ErrInvalidSpecification
+	}
+	s = s.Elem()
+	if s.Kind() != reflect.Struct {
+		return nil, envconfig.ErrInvalidSpecification
+	}
+	typeOfSpec := s.Type()
+	infos := make([]varInfo, 0, s.NumField())
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Field(i)
+		ftype := typeOfSpec.Field(i)
+		if !f.CanSet() || ftype.Tag.Get("ignored") == "true" {
+			continue
+		}
+		for f.Kind() == reflect.Ptr {
+			if f.IsNil() {
+				if f.Type().Elem().Kind() != reflect.Struct {
+					// nil pointer to a non-struct: leave it alone
+					break
+				}
+				// nil pointer to struct: create a zero instance
+				f.Set(reflect.New(f.Type().Elem()))
+			}
+			f = f.Elem()
+		}
+		info := varInfo{
+			Name:  ftype.Name,
+			Field: f,
+			Tags:  ftype.Tag,
+			Alt:   strings.ToUpper(ftype.Tag.Get("envconfig")),
+			Path:  append(path[:len(path):len(path)], ftype),
+		}
+		info.Key = naming.key(prefix, ftype)
+		info.Indexed = indexed(f.Type())
+		if f.Kind() == reflect.Struct && decoderFrom(f) == nil && setterFrom(f) == nil && textUnmarshaler(f) == nil {
+			innerPrefix := prefix
+			if !ftype.Anonymous {
+				innerPrefix = info.Key
+			}
+			embeddedInfos, err := gatherInfo(naming, innerPrefix, f.Addr().Interface(), info.Path)
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, embeddedInfos...)
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// indexed checks if the type is a slice or map of structs
+func indexed(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Slice, reflect.Map:
+		t = t.Elem()
+	default:
+		return false
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	p := reflect.PtrTo(t)
+	return !p.Implements(decoderType) && !p.Implements(setterType) && !p.Implements(textUnmarshalerType)
+}
+
+var (
+	decoderType         = reflect.TypeOf((*envconfig.Decoder)(nil)).Elem()
+	setterType          = reflect.TypeOf((*envconfig.Setter)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// process populates the specified struct with the values provided by lookup.
+// The keys are all the variable names known, used to find indexed variables.
+func process(naming Naming, prefix string, spec interface{}, keys []string, lookup func(key string) (string, bool, error)) error {
+	infos, err := gatherInfo(naming, prefix, spec, nil)
+	if err != nil {
+		return loader.Errors.Wrap(err, "gathering specification")
+	}
+	for _, info := range infos {
+		if info.Indexed {
+			ok, err := processIndexed(naming, info, keys, lookup)
+			if err != nil {
+				return err
+			}
+			if ok {
+				continue
+			}
+		}
+		value, ok, err := lookup(info.Key)
+		if err == nil && !ok && info.Alt != "" {
+			value, ok, err = lookup(info.Alt)
+		}
+		if err != nil {
+			return err
+		}
+		def := info.Tags.Get("default")
+		if def != "" && !ok {
+			value = def
+		}
+		if !ok && def == "" {
+			if info.Tags.Get("required") == "true" {
+				return loader.DecodeErrors.Errorf("required key %s missing value", info.Key)
+			}
+			continue
+		}
+		if err := processField(value, info.Field); err != nil {
+			return loader.DecodeErrors.Wrap(&envconfig.ParseError{
+				KeyName:   info.Key,
+				FieldName: info.Name,
+				TypeName:  info.Field.Type().String(),
+				Value:     value,
+				Err:       err,
+			}, "processing field")
+		}
+	}
+	return nil
+}
+
+// processIndexed populates slice or map of structs from the variables named
+// after the field, index or map key and the element field names. Like in
+// libkv loader, slice elements are ordered by index, with the gaps removed.
+// It reports whether any of such variables are found.
+func processIndexed(naming Naming, info varInfo, keys []string, lookup func(key string) (string, bool, error)) (bool, error) {
+	prefix := info.Key + naming.Separator
+	var (
+		segments []string
+		seen     = make(map[string]bool)
+	)
+	for _, k := range keys {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		rest := k[len(prefix):]
+		i := strings.Index(rest, naming.Separator)
+		if i <= 0 {
+			continue
+		}
+		if seg := rest[:i]; !seen[seg] {
+			seen[seg] = true
+			segments = append(segments, seg)
+		}
+	}
+	if len(segments) == 0 {
+		return false, nil
+	}
+	typ := info.Field.Type()
+	elem := func(seg string) (reflect.Value, error) {
+		t := typ.Elem()
+		depth := 0
+		for ; t.Kind() == reflect.Ptr; depth++ {
+			t = t.Elem()
+		}
+		v := reflect.New(t)
+		if err := process(naming, prefix+seg, v.Interface(), keys, lookup); err != nil {
+			return v, err
+		}
+		if depth == 0 {
+			return v.Elem(), nil
+		}
+		for ; depth > 1; depth-- {
+			p := reflect.New(v.Type())
+			p.Elem().Set(v)
+			v = p
+		}
+		return v, nil
+	}
+	switch typ.Kind() {
+	case reflect.Slice:
+		indexes := make([]int, len(segments))
+		for i, seg := range segments {
+			n, err := strconv.Atoi(seg)
+			if err != nil || n < 0 {
+				return false, loader.DecodeErrors.Errorf("%s%s: invalid index %q", prefix, seg, seg)
+			}
+			indexes[i] = n
+		}
+		sort.Ints(indexes)
+		sl := reflect.MakeSlice(typ, len(indexes), len(indexes))
+		for i, n := range indexes {
+			v, err := elem(strconv.Itoa(n))
+			if err != nil {
+				return false, err
+			}
+			sl.Index(i).Set(v)
+		}
+		info.Field.Set(sl)
+	case reflect.Map:
+		mp := reflect.MakeMap(typ)
+		for _, seg := range segments {
+			k := reflect.New(typ.Key()).Elem()
+			if err := processField(seg, k); err != nil {
+				return false, loader.DecodeErrors.Wrapf(err, "%s%s: invalid map key", prefix, seg)
+			}
+			v, err := elem(seg)
+			if err != nil {
+				return false, err
+			}
+			mp.SetMapIndex(k, v)
+		}
+		info.Field.Set(mp)
+	}
+	return true, nil
+}
+
+func processField(value string, field reflect.Value) error {
+	typ := field.Type()
+	if decoder := decoderFrom(field); decoder != nil {
+		return decoder.Decode(value)
+	}
+	if setter := setterFrom(field); setter != nil {
+		return setter.Set(value)
+	}
+	if t := textUnmarshaler(field); t != nil {
+		return t.UnmarshalText([]byte(value))
+	}
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+		if field.IsNil() {
+			field.Set(reflect.New(typ))
+		}
+		field = field.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var (
+			val int64
+			err error
+		)
+		if field.Kind() == reflect.Int64 && typ.PkgPath() == "time" && typ.Name() == "Duration" {
+			var d time.Duration
+			d, err = time.ParseDuration(value)
+			val = int64(d)
+		} else {
+			val, err = strconv.ParseInt(value, 0, typ.Bits())
+		}
+		if err != nil {
+			return err
+		}
+		field.SetInt(val)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		val, err := strconv.ParseUint(value, 0, typ.Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(val)
+	case reflect.Bool:
+		val, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(val)
+	case reflect.Float32, reflect.Float64:
+		val, err := strconv.ParseFloat(value, typ.Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(val)
+	case reflect.Slice:
+		vals := strings.Split(value, ",")
+		sl := reflect.MakeSlice(typ, len(vals), len(vals))
+		for i, val := range vals {
+			if err := processField(val, sl.Index(i)); err != nil {
+				return err
+			}
+		}
+		field.Set(sl)
+	case reflect.Map:
+		mp := reflect.MakeMap(typ)
+		for _, pair := range strings.Split(value, ",") {
+			kvpair := strings.Split(pair, ":")
+			if len(kvpair) != 2 {
+				return fmt.Errorf("invalid map item: %q", pair)
+			}
+			k := reflect.New(typ.Key()).Elem()
+			if err := processField(kvpair[0], k); err != nil {
+				return err
+			}
+			v := reflect.New(typ.Elem()).Elem()
+			if err := processField(kvpair[1], v); err != nil {
+				return err
+			}
+			mp.SetMapIndex(k, v)
+		}
+		field.Set(mp)
+	}
+	return nil
+}
+
+func interfaceFrom(field reflect.Value, fn func(interface{}, *bool)) {
+	if !field.CanInterface() {
+		return
+	}
+	var ok bool
+	fn(field.Interface(), &ok)
+	if !ok && field.CanAddr() {
+		fn(field.Addr().Interface(), &ok)
+	}
+}
+
+func decoderFrom(field reflect.Value) (d envconfig.Decoder) {
+	interfaceFrom(field, func(v interface{}, ok *bool) { d, *ok = v.(envconfig.Decoder) })
+	return d
+}
+
+func setterFrom(field reflect.Value) (s envconfig.Setter) {
+	interfaceFrom(field, func(v interface{}, ok *bool) { s, *ok = v.(envconfig.Setter) })
+	return s
+}
+
+func textUnmarshaler(field reflect.Value) (t encoding.TextUnmarshaler) {
+	interfaceFrom(field, func(v interface{}, ok *bool) { t, *ok = v.(encoding.TextUnmarshaler) })
+	return t
+}
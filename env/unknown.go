@@ -0,0 +1,99 @@
+package env
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-mixins/loader"
+)
+
+// UnknownVar is the variable carrying the loader prefix, but not matching
+// any field of the target object
+type UnknownVar struct {
+	Key string
+	// Suggestion is the closest known variable name, if any
+	Suggestion string
+}
+
+func (u UnknownVar) String() string {
+	if u.Suggestion == "" {
+		return u.Key
+	}
+	return fmt.Sprintf("%s (did you mean %s?)", u.Key, u.Suggestion)
+}
+
+// UnknownHandler is called by Load with unknown variables found in the
+// sources able to list the variables. Load fails if the handler returns
+// error, so the handler may either log a warning or reject the
+// configuration.
+type UnknownHandler func(vars []UnknownVar) error
+
+// RejectUnknown is UnknownHandler failing Load
+func RejectUnknown(vars []UnknownVar) error {
+	names := make([]string, len(vars))
+	for i := range vars {
+		names[i] = vars[i].String()
+	}
+	return loader.Errors.Errorf("unknown variables: %s", strings.Join(names, ", "))
+}
+
+// findUnknown lists the keys with the prefix that are not known
+func findUnknown(prefix string, keys []string, known map[string]bool) (res []UnknownVar) {
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) || known[key] || known[strings.TrimSuffix(key, FileSuffix)] {
+			continue
+		}
+		res = append(res, UnknownVar{Key: key, Suggestion: suggest(key, known)})
+	}
+	return
+}
+
+// suggest finds the closest known key with the edit distance within a third
+// of the key length
+func suggest(key string, known map[string]bool) (res string) {
+	suffix := ""
+	if strings.HasSuffix(key, FileSuffix) {
+		key, suffix = strings.TrimSuffix(key, FileSuffix), FileSuffix
+	}
+	best := len(key)/3 + 1
+	for k := range known {
+		if d := distance(key, k); d < best || d == best && res != "" && k < res {
+			best, res = d, k
+		}
+	}
+	if res != "" {
+		res += suffix
+	}
+	return
+}
+
+// distance is Levenshtein distance between the strings
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
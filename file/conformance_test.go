@@ -0,0 +1,39 @@
+package file_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/file"
+	"github.com/go-mixins/loader/loadertest"
+)
+
+func TestConformance(t *testing.T) {
+	defer func(timeout int) { file.DebounceTimeout = timeout }(file.DebounceTimeout)
+	file.DebounceTimeout = 100
+	loadertest.Run(t, func(t *testing.T) loadertest.Subject {
+		td, err := ioutil.TempDir("", "loader")
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		t.Cleanup(func() { os.RemoveAll(td) })
+		name := filepath.Join(td, "config.yaml")
+		return loadertest.Subject{
+			Store: func(src interface{}) error {
+				data, err := yaml.Marshal(src)
+				if err != nil {
+					return err
+				}
+				return ioutil.WriteFile(name, data, 0644)
+			},
+			New:      func() loader.Loader { return file.Open(name) },
+			Watch:    true,
+			Debounce: true,
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/crypt"
+	"github.com/go-mixins/loader/internal/watch"
+)
+
+// DirLoader implements loader.Loader for a set of files, merged in lexical
+// order so that the latter files override the former ones
+type DirLoader struct {
+	pattern string
+	watcher *watch.Watcher
+	err     error
+}
+
+var _ loader.Loader = (*DirLoader)(nil)
+
+// Dir creates DirLoader for a glob pattern like "/etc/app/conf.d/*.yaml". If
+// the pattern is a directory, all the files inside it are loaded, except the
+// hidden ones. Every file is parsed in the format registered for its
+// extension. The merged result is decoded following JSON rules if all the
+// files are JSON, and following YAML rules otherwise. The directories
+// matching the pattern are watched, the ones appearing later are put under
+// watch by Load.
+func Dir(pattern string) (res *DirLoader) {
+	res = &DirLoader{pattern: pattern}
+	if fi, err := os.Stat(pattern); err == nil && fi.IsDir() {
+		res.pattern = filepath.Join(pattern, "[^.]*")
+	}
+	if _, res.err = filepath.Match(res.pattern, ""); res.err != nil {
+		res.err = loader.Errors.Wrapf(res.err, "invalid pattern %q", pattern)
+	}
+	var err error
+	if res.watcher, err = watch.New(&DebounceTimeout, res.match); err != nil {
+		res.err = err
+		return
+	}
+	if res.err == nil {
+		res.err = res.watchDirs()
+	}
+	return
+}
+
+// watchDirs puts the directories matching the pattern under watch
+func (l *DirLoader) watchDirs() error {
+	dir := filepath.Dir(l.pattern)
+	if !strings.ContainsAny(dir, "*?[") {
+		return l.watcher.Add(dir)
+	}
+	dirs, err := filepath.Glob(dir)
+	if err != nil {
+		return loader.Errors.Wrapf(err, "listing %q", dir)
+	}
+	for _, dir := range dirs {
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			continue
+		}
+		if err = l.watcher.Add(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *DirLoader) match(name string) bool {
+	ok, _ := filepath.Match(l.pattern, name)
+	return ok
+}
+
+// Close stops background process and releases FS watcher
+func (l *DirLoader) Close() error {
+	return l.watcher.Close()
+}
+
+// Changes provides source of config change events. Any file matching the
+// pattern being created, removed or modified causes a single event after
+// DebounceTimeout.
+func (l *DirLoader) Changes() <-chan struct{} {
+	return l.watcher.Changes()
+}
+
+// Files returns the names of the files to load in order of merging
+func (l *DirLoader) Files() ([]string, error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+	names, err := filepath.Glob(l.pattern)
+	if err != nil {
+		return nil, loader.Errors.Wrapf(err, "listing %q", l.pattern)
+	}
+	res := names[:0]
+	for _, name := range names {
+		if fi, err := os.Stat(name); err != nil || fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// Load merges all the files and decodes the result into target object
+func (l *DirLoader) Load(dest interface{}) error {
+	names, err := l.Files()
+	if err != nil {
+		return err
+	}
+	if err = l.watchDirs(); err != nil {
+		return err
+	}
+	var (
+		tree      interface{}
+		inc       includer
+		jsonRules = len(names) > 0
+	)
+	for _, name := range names {
+		jsonRules = jsonRules && isJSON(name)
+		data, err := inc.load(name)
+		if err != nil {
+			return err
+		}
+		tree = merge(tree, data)
+	}
+	if err = l.watcher.SetExtra(inc.included); err != nil {
+		return err
+	}
+	if tree, err = crypt.DecryptTree(tree); err != nil {
+		return err
+	}
+	return decodeTree(tree, dest, jsonRules)
+}
@@ -0,0 +1,124 @@
+package file_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/go-mixins/loader/file"
+)
+
+type dirConfig struct {
+	Name    string
+	Port    int
+	Tags    []string
+	Backend struct {
+		Host string
+		Port int
+	}
+}
+
+func TestDirLoader(t *testing.T) {
+	td, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer os.RemoveAll(td)
+	for name, data := range map[string]string{
+		"10-base.yaml": "name: app\nport: 80\ntags: [a, b]\nbackend:\n  host: localhost\n  port: 8080\n",
+		"20-site.json": `{"port": 8000, "backend": {"host": "db"}}`,
+		"30-tags.yml":  "tags: [c]\n",
+		".hidden.yaml": "name: hidden\n",
+		"skip.txt":     "name: skipped\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(td, name), []byte(data), 0644); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	l := file.Dir(filepath.Join(td, "*.y*ml"))
+	defer l.Close()
+	var dest dirConfig
+	if err := file.Dir(filepath.Join(td, "[")).Load(&dest); err == nil {
+		t.Error("invalid pattern must fail")
+	}
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := dirConfig{Name: "app", Port: 80, Tags: []string{"c"}}
+	expect.Backend.Host = "localhost"
+	expect.Backend.Port = 8080
+	if diff := deep.Equal(expect, dest); diff != nil {
+		t.Errorf("%+v", diff)
+	}
+	all := file.Dir(td)
+	defer all.Close()
+	dest = dirConfig{}
+	if err := all.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect.Name = "skipped"
+	expect.Port = 8000
+	expect.Backend.Host = "db"
+	if diff := deep.Equal(expect, dest); diff != nil {
+		t.Errorf("%+v", diff)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		ioutil.WriteFile(filepath.Join(td, "40-new.yaml"), []byte("port: 1\n"), 0644)
+		ioutil.WriteFile(filepath.Join(td, "ignored.json"), []byte("{}"), 0644)
+		os.Remove(filepath.Join(td, "30-tags.yml"))
+	}()
+	select {
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for change")
+	case <-l.Changes():
+	}
+	select {
+	case <-time.After(time.Duration(2*file.DebounceTimeout) * time.Millisecond):
+	case <-l.Changes():
+		t.Error("changes must be debounced")
+	}
+}
+
+func TestDirLoader_Globs(t *testing.T) {
+	td, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer os.RemoveAll(td)
+	for name, data := range map[string]string{
+		"a/10-base.json": `{"service_name": "app", "port": 80}`,
+		"b/20-site.json": `{"port": 8000}`,
+	} {
+		name = filepath.Join(td, name)
+		os.MkdirAll(filepath.Dir(name), 0755)
+		if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	l := file.Dir(filepath.Join(td, "*", "*.json"))
+	defer l.Close()
+	var dest struct {
+		Name string `json:"service_name"`
+		Port int
+	}
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dest.Name != "app" || dest.Port != 8000 {
+		t.Errorf("invalid result %+v", dest)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		ioutil.WriteFile(filepath.Join(td, "b", "30-new.json"), []byte(`{"port": 1}`), 0644)
+	}()
+	select {
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for change")
+	case <-l.Changes():
+	}
+}
@@ -0,0 +1,89 @@
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/go-mixins/loader"
+)
+
+var (
+	formatsLock sync.RWMutex
+	formats     = map[string]UnmarshalFunc{
+		".json": json.Unmarshal,
+		".yaml": yaml.Unmarshal,
+		".yml":  yaml.Unmarshal,
+	}
+)
+
+// RegisterFormat associates file name extension with the UnmarshalFunc. The
+// extension is case-insensitive and may be given with or without the leading
+// dot. Registering the same extension again replaces the previous function,
+// registering nil removes it.
+func RegisterFormat(ext string, f UnmarshalFunc) {
+	ext = normalizeExt(ext)
+	formatsLock.Lock()
+	defer formatsLock.Unlock()
+	if f == nil {
+		delete(formats, ext)
+		return
+	}
+	formats[ext] = f
+}
+
+// Format returns UnmarshalFunc registered for the file name extension. If
+// there is none, Sniff is returned.
+func Format(name string) UnmarshalFunc {
+	if f, ok := LookupFormat(name); ok {
+		return f
+	}
+	return Sniff
+}
+
+// LookupFormat returns UnmarshalFunc registered for the file name extension,
+// if any
+func LookupFormat(name string) (UnmarshalFunc, bool) {
+	formatsLock.RLock()
+	defer formatsLock.RUnlock()
+	f, ok := formats[normalizeExt(filepath.Ext(name))]
+	return f, ok
+}
+
+// Parse decodes the document into generic tree of map[string]interface{},
+// []interface{} and scalar values. The format is chosen by the name as in
+// Format.
+func Parse(name string, data []byte) (interface{}, error) {
+	var res interface{}
+	if err := Format(name)(data, &res); err != nil {
+		return nil, loader.DecodeErrors.Wrapf(err, "parsing %q", name)
+	}
+	return Normalize(res), nil
+}
+
+// Sniff guesses data format by its content. Objects and arrays in curly or
+// square brackets are parsed as JSON, everything else as YAML.
+func Sniff(data []byte, dest interface{}) error {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed) {
+		return json.Unmarshal(trimmed, dest)
+	}
+	return yaml.Unmarshal(data, dest)
+}
+
+// Open returns loader with the format chosen by file name extension
+func Open(name string) *Loader {
+	return New(name, Format(name))
+}
+
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(ext)
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
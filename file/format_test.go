@@ -0,0 +1,45 @@
+package file_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-mixins/loader/file"
+)
+
+func TestOpen(t *testing.T) {
+	td, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer os.RemoveAll(td)
+	file.RegisterFormat("TXT", func(data []byte, dest interface{}) error {
+		*(dest.(*struct{ A string })) = struct{ A string }{strings.TrimSpace(string(data))}
+		return nil
+	})
+	defer file.RegisterFormat(".txt", nil)
+	for name, data := range map[string]string{
+		"a.json": `{"A": "x"}`,
+		"a.yml":  `a: x`,
+		"a.yaml": `a: x`,
+		"a.TXT":  `x`,
+		"a.conf": `{"A": "x"}`,
+		"a":      `a: x`,
+	} {
+		path := filepath.Join(td, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		var dest struct{ A string }
+		l := file.Open(path)
+		if err := l.Load(&dest); err != nil {
+			t.Errorf("%s: %+v", name, err)
+		} else if dest.A != "x" {
+			t.Errorf("%s: invalid result %+v", name, dest)
+		}
+		l.Close()
+	}
+}
@@ -0,0 +1,185 @@
+package file
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	yaml3 "gopkg.in/yaml.v3"
+
+	"github.com/go-mixins/loader"
+)
+
+const (
+	// IncludeKey is the mapping key listing files to merge below the rest of
+	// the mapping. It is recognized at any level of the document.
+	IncludeKey = "$include"
+	// ExtendsKey is the top-level key listing base files of the document
+	ExtendsKey = "$extends"
+
+	includeTag = "!include"
+)
+
+// expandIncludeTags replaces `!include name` values of YAML document with
+// `{"$include": "name"}` mappings, since YAML parser drops unknown tags. The
+// data that is not YAML or has no such values is returned as is.
+func expandIncludeTags(data []byte) []byte {
+	if !bytes.Contains(data, []byte(includeTag)) {
+		return data
+	}
+	var doc yaml3.Node
+	if err := yaml3.Unmarshal(data, &doc); err != nil || !replaceIncludeTags(&doc) {
+		return data
+	}
+	res, err := yaml3.Marshal(&doc)
+	if err != nil {
+		return data
+	}
+	return res
+}
+
+func replaceIncludeTags(n *yaml3.Node) (found bool) {
+	if n.Kind == yaml3.ScalarNode && n.Tag == includeTag {
+		*n = yaml3.Node{
+			Kind: yaml3.MappingNode,
+			Tag:  "!!map",
+			Content: []*yaml3.Node{
+				{Kind: yaml3.ScalarNode, Tag: "!!str", Value: IncludeKey},
+				{Kind: yaml3.ScalarNode, Tag: "!!str", Value: n.Value},
+			},
+		}
+		return true
+	}
+	for _, c := range n.Content {
+		if replaceIncludeTags(c) {
+			found = true
+		}
+	}
+	return
+}
+
+// mayInclude quickly checks if the data could contain include directives.
+// The directives are then looked for in the parsed document.
+func mayInclude(data []byte) bool {
+	return bytes.Contains(data, []byte(includeTag)) ||
+		bytes.Contains(data, []byte(IncludeKey)) ||
+		bytes.Contains(data, []byte(ExtendsKey))
+}
+
+// includer resolves include directives keeping track of the included files
+type includer struct {
+	stack    []string
+	included []string
+	// found is set when any directive is resolved
+	found bool
+}
+
+// parse unmarshals data of the named file into generic tree, resolving
+// include directives
+func (inc *includer) parse(name string, data []byte, f UnmarshalFunc) (interface{}, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return nil, loader.Errors.Wrapf(err, "resolving %q", name)
+	}
+	for _, s := range inc.stack {
+		if s == abs {
+			return nil, loader.Errors.Errorf("include cycle: %s -> %s", strings.Join(inc.stack, " -> "), abs)
+		}
+	}
+	var tree interface{}
+	if err = f(expandIncludeTags(data), &tree); err != nil {
+		return nil, loader.DecodeErrors.Wrapf(err, "unmarshal %q", name)
+	}
+	inc.stack = append(inc.stack, abs)
+	defer func() { inc.stack = inc.stack[:len(inc.stack)-1] }()
+	return inc.resolve(Normalize(tree), filepath.Dir(abs), true)
+}
+
+// load reads the named file in the format registered for its extension
+func (inc *includer) load(name string) (interface{}, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, loader.Errors.Wrap(err, "read file")
+	}
+	if len(inc.stack) > 0 {
+		inc.included = append(inc.included, name)
+	}
+	return inc.parse(name, data, Format(name))
+}
+
+func (inc *includer) resolve(node interface{}, dir string, root bool) (interface{}, error) {
+	switch v := node.(type) {
+	case []interface{}:
+		for i := range v {
+			val, err := inc.resolve(v[i], dir, false)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = val
+		}
+		return v, nil
+	case map[string]interface{}:
+		var names []string
+		for _, key := range []string{ExtendsKey, IncludeKey} {
+			if key == ExtendsKey && !root {
+				continue
+			}
+			val, ok := v[key]
+			if !ok {
+				continue
+			}
+			list, err := includeNames(key, val)
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, list...)
+			delete(v, key)
+			inc.found = true
+		}
+		for k := range v {
+			val, err := inc.resolve(v[k], dir, false)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = val
+		}
+		if len(names) == 0 {
+			return v, nil
+		}
+		var base interface{}
+		for _, name := range names {
+			if !filepath.IsAbs(name) {
+				name = filepath.Join(dir, name)
+			}
+			sub, err := inc.load(name)
+			if err != nil {
+				return nil, loader.Errors.Wrapf(err, "including %q", name)
+			}
+			base = merge(base, sub)
+		}
+		if len(v) == 0 {
+			return base, nil
+		}
+		return merge(base, v), nil
+	}
+	return node, nil
+}
+
+func includeNames(key string, val interface{}) ([]string, error) {
+	switch v := val.(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		res := make([]string, len(v))
+		for i := range v {
+			s, ok := v[i].(string)
+			if !ok {
+				return nil, loader.Errors.Errorf("%s: file name must be a string, not %#v", key, v[i])
+			}
+			res[i] = s
+		}
+		return res, nil
+	}
+	return nil, loader.Errors.Errorf("%s: expecting file name or list of names, not %#v", key, val)
+}
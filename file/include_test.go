@@ -0,0 +1,195 @@
+package file_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/go-mixins/loader/file"
+)
+
+type includeConfig struct {
+	Name     string
+	Port     int
+	Backends []struct {
+		Host string
+	}
+	DB struct {
+		Host string
+		User string
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, data := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+}
+
+func TestLoader_Include(t *testing.T) {
+	td, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer os.RemoveAll(td)
+	writeFiles(t, td, map[string]string{
+		"base/common.yaml":  "name: common\nport: 80\ndb: !include db.json\n",
+		"base/db.json":      `{"host": "localhost", "user": "root"}`,
+		"backend.yaml":      "host: b1\n",
+		"service.yaml":      "$extends: base/common.yaml\nport: 8080\nbackends:\n  - !include backend.yaml\n  - host: b2\ndb:\n  user: svc\n",
+		"service.json":      `{"$include": ["base/common.yaml"], "db": {"$include": "base/db.json", "user": "svc"}}`,
+		"cycle/a.yaml":      "a: !include 'b.yaml'\n",
+		"cycle/b.yaml":      "b: !include \"a.yaml\"\n",
+		"notfound/a.yaml":   "a: !include missing.yaml\n",
+		"badinclude/a.json": `{"$include": 1}`,
+	})
+	var dest includeConfig
+	l := file.YAML(filepath.Join(td, "service.yaml"))
+	defer l.Close()
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := includeConfig{Name: "common", Port: 8080}
+	expect.Backends = append(expect.Backends, struct{ Host string }{"b1"}, struct{ Host string }{"b2"})
+	expect.DB.Host = "localhost"
+	expect.DB.User = "svc"
+	if diff := deep.Equal(expect, dest); diff != nil {
+		t.Errorf("%+v", diff)
+	}
+	dest = includeConfig{}
+	jl := file.JSON(filepath.Join(td, "service.json"))
+	defer jl.Close()
+	if err := jl.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect.Port = 80
+	expect.Backends = nil
+	if diff := deep.Equal(expect, dest); diff != nil {
+		t.Errorf("%+v", diff)
+	}
+	for _, name := range []string{"cycle/a.yaml", "notfound/a.yaml", "badinclude/a.json"} {
+		fl := file.Open(filepath.Join(td, name))
+		if err := fl.Load(&dest); err == nil {
+			t.Errorf("%s: expecting error", name)
+		}
+		fl.Close()
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		ioutil.WriteFile(filepath.Join(td, "base/db.json"), []byte(`{"host": "db"}`), 0644)
+	}()
+	select {
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for change")
+	case <-l.Changes():
+	}
+}
+
+func TestLoader_IncludeLookalikes(t *testing.T) {
+	td, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer os.RemoveAll(td)
+	writeFiles(t, td, map[string]string{
+		"app.yaml": "extends: base\n" +
+			"note: \"see !include docs\"\n" +
+			"quote: 'it''s !include x'\n" +
+			"plain: a !include b\n" +
+			"multi: first\n  !include second\n" +
+			"script: |\n  !include none\n  - !include none\n" +
+			"db: !include db.yaml # comment !include x\n" +
+			"list: [!include item.yaml, {name: \"!include x\"}]\n",
+		"db.yaml":   "host: db\n",
+		"item.yaml": "name: item\n",
+		"raw.json":  `{"extends": "base", "note": "$include"}`,
+	})
+	var dest struct {
+		Extends string
+		Note    string
+		Quote   string
+		Plain   string
+		Multi   string
+		Script  string
+		DB      struct{ Host string }
+		List    []struct{ Name string }
+	}
+	l := file.YAML(filepath.Join(td, "app.yaml"))
+	defer l.Close()
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dest.Extends != "base" || dest.Note != "see !include docs" || dest.Quote != "it's !include x" ||
+		dest.Plain != "a !include b" || dest.Multi != "first !include second" || dest.Script != "!include none\n- !include none\n" || dest.DB.Host != "db" {
+		t.Errorf("invalid result %#v", dest)
+	}
+	if diff := deep.Equal(dest.List, []struct{ Name string }{{"item"}, {"!include x"}}); diff != nil {
+		t.Errorf("%+v", diff)
+	}
+	// The data without directives is passed to UnmarshalFunc as is
+	var got []byte
+	raw := file.New(filepath.Join(td, "raw.json"), func(data []byte, dest interface{}) error {
+		if _, ok := dest.(*interface{}); !ok {
+			got = data
+		}
+		return json.Unmarshal(data, dest)
+	})
+	defer raw.Close()
+	if err := raw.Load(new(struct{ Extends string })); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if string(got) != `{"extends": "base", "note": "$include"}` {
+		t.Errorf("data must be passed as is, got %s", got)
+	}
+}
+
+func TestLoader_IncludeCustomFormat(t *testing.T) {
+	td, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer os.RemoveAll(td)
+	writeFiles(t, td, map[string]string{
+		"app.conf": "$include=db.yaml\nname=app\n",
+		"db.yaml":  "db:\n  host: db\n",
+	})
+	// the format can only parse its own data into generic tree
+	conf := func(data []byte, dest interface{}) error {
+		tree, ok := dest.(*interface{})
+		if !ok {
+			return errors.New("unexpected target")
+		}
+		res := make(map[string]interface{})
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			kv := strings.SplitN(line, "=", 2)
+			if len(kv) != 2 {
+				return errors.New("expecting key=value")
+			}
+			res[kv[0]] = kv[1]
+		}
+		*tree = res
+		return nil
+	}
+	l := file.New(filepath.Join(td, "app.conf"), conf)
+	defer l.Close()
+	var dest includeConfig
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dest.Name != "app" || dest.DB.Host != "db" {
+		t.Errorf("invalid result %+v", dest)
+	}
+}
@@ -3,12 +3,12 @@ package file
 import (
 	"encoding/json"
 	"io/ioutil"
-	"time"
 
-	"github.com/go-fsnotify/fsnotify"
 	yaml "gopkg.in/yaml.v2"
 
 	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/crypt"
+	"github.com/go-mixins/loader/internal/watch"
 )
 
 // DebounceTimeout defines change event settle time in milliseconds
@@ -19,12 +19,11 @@ type UnmarshalFunc func(data []byte, dest interface{}) error
 
 // Loader implements loader.Loader for a generic file on disk
 type Loader struct {
-	name          string
-	stop, changes chan struct{}
-	result        chan error
-	watcher       *fsnotify.Watcher
-	err           error
-	f             UnmarshalFunc
+	name    string
+	watcher *watch.Watcher
+	err     error
+	f       UnmarshalFunc
+	json    bool
 }
 
 var _ loader.Loader = (*Loader)(nil)
@@ -32,53 +31,22 @@ var _ loader.Loader = (*Loader)(nil)
 // New creates Loader initialized with a file name
 func New(name string, f UnmarshalFunc) (res *Loader) {
 	res = &Loader{
-		name:    name,
-		f:       f,
-		stop:    make(chan struct{}),
-		changes: make(chan struct{}, 1),
-		result:  make(chan error, 1),
+		name: name,
+		f:    f,
+		json: isJSON(name),
 	}
-	if res.watcher, res.err = fsnotify.NewWatcher(); res.err != nil {
-		res.err = loader.Errors.Wrap(res.err, "creating fsnotify watcher")
-		return
-	}
-	go func() {
-		defer close(res.changes)
-		defer func() {
-			res.result <- res.watcher.Close()
-			close(res.result)
-		}()
-		for {
-			select {
-			case <-res.stop:
-				return
-			case <-res.watcher.Events:
-			loop:
-				for {
-					// This loop will consume consecutive change events that
-					// will come during DebounceTimeout. Only the last
-					// one will be reported, after the timeout expires.
-					select {
-					case <-res.watcher.Events:
-						break
-					case <-time.After(time.Duration(DebounceTimeout) * time.Millisecond):
-						break loop
-					}
-				}
-				res.changes <- struct{}{}
-			}
-		}
-	}()
-	if res.err = res.watcher.Add(res.name); res.err != nil {
-		res.err = loader.Errors.Wrapf(res.err, "adding %q to watcher", res.name)
+	if res.watcher, res.err = watch.New(&DebounceTimeout, nil); res.err != nil {
 		return
 	}
+	res.err = res.watcher.Add(res.name)
 	return
 }
 
 // JSON returns loader of JSON format
 func JSON(name string) *Loader {
-	return New(name, json.Unmarshal)
+	res := New(name, json.Unmarshal)
+	res.json = true
+	return res
 }
 
 // YAML returns loader of YAML format
@@ -88,17 +56,19 @@ func YAML(name string) *Loader {
 
 // Close stops background process and releases FS watcher
 func (l *Loader) Close() error {
-	close(l.stop)
-	return loader.Errors.Wrap(<-l.result, "closing watcher")
+	return l.watcher.Close()
 }
 
-// Changes provides source of config change events. For environment variables
-// there will never be any.
+// Changes provides source of config change events
 func (l *Loader) Changes() <-chan struct{} {
-	return l.changes
+	return l.watcher.Changes()
 }
 
-// Load target object from a file
+// Load target object from a file. The files referenced with include
+// directives are merged in and put under watch, and encrypted values are
+// decrypted. In that case the resulting tree is decoded following JSON rules
+// for JSON files and YAML rules otherwise, like in DirLoader. The file data
+// without directives and encrypted values is passed to UnmarshalFunc as is.
 func (l *Loader) Load(dest interface{}) error {
 	if l.err != nil {
 		return l.err
@@ -107,5 +77,24 @@ func (l *Loader) Load(dest interface{}) error {
 	if err != nil {
 		return loader.Errors.Wrap(err, "read file")
 	}
-	return loader.Errors.Wrap(l.f(data, dest), "unmarshal data")
+	var inc includer
+	if mayInclude(data) || crypt.Contains(data) {
+		tree, err := inc.parse(l.name, data, l.f)
+		if err != nil {
+			return err
+		}
+		if inc.found || crypt.Contains(data) {
+			if err = l.watcher.SetExtra(inc.included); err != nil {
+				return err
+			}
+			if tree, err = crypt.DecryptTree(tree); err != nil {
+				return err
+			}
+			return decodeTree(tree, dest, l.json)
+		}
+	}
+	if err = l.watcher.SetExtra(inc.included); err != nil {
+		return err
+	}
+	return loader.DecodeErrors.Wrap(l.f(data, dest), "unmarshal data")
 }
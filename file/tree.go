@@ -0,0 +1,75 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/go-mixins/loader"
+)
+
+// Normalize converts maps produced by YAML parser to map[string]interface{}
+// recursively, so that the tree can be encoded as JSON
+func Normalize(src interface{}) interface{} {
+	switch v := src.(type) {
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			res[fmt.Sprint(k)] = Normalize(val)
+		}
+		return res
+	case map[string]interface{}:
+		for k, val := range v {
+			v[k] = Normalize(val)
+		}
+		return v
+	case []interface{}:
+		for i, val := range v {
+			v[i] = Normalize(val)
+		}
+		return v
+	}
+	return src
+}
+
+// merge puts src over dst recursively. Maps are merged key by key, all other
+// values, including slices, are replaced.
+func merge(dst, src interface{}) interface{} {
+	srcMap, ok := src.(map[string]interface{})
+	if !ok {
+		return src
+	}
+	dstMap, ok := dst.(map[string]interface{})
+	if !ok {
+		return src
+	}
+	for k, v := range srcMap {
+		if old, ok := dstMap[k]; ok {
+			v = merge(old, v)
+		}
+		dstMap[k] = v
+	}
+	return dstMap
+}
+
+// decodeTree puts the merged tree into dest. The tree of JSON documents is
+// decoded following JSON rules, honoring `json` tags, any other one following
+// YAML rules.
+func decodeTree(tree interface{}, dest interface{}, jsonRules bool) error {
+	marshal, unmarshal := yaml.Marshal, yaml.Unmarshal
+	if jsonRules {
+		marshal, unmarshal = json.Marshal, json.Unmarshal
+	}
+	data, err := marshal(tree)
+	if err != nil {
+		return loader.Errors.Wrap(err, "marshal merged data")
+	}
+	return loader.DecodeErrors.Wrap(unmarshal(data, dest), "unmarshal merged data")
+}
+
+func isJSON(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".json")
+}
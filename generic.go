@@ -0,0 +1,121 @@
+//go:build go1.18
+
+package loader
+
+import "sync"
+
+// LoadAs loads new value of type T, which is usually a struct
+func LoadAs[T any](l Loader) (T, error) {
+	var res T
+	err := l.Load(&res)
+	return res, err
+}
+
+// Value holds configuration of type T, reloading it on every change of the
+// loader. Every reload produces new value, so that the values returned by
+// Get are never modified.
+type Value[T any] struct {
+	l    Loader
+	stop chan struct{}
+	done chan struct{}
+
+	lock   sync.RWMutex
+	val    T
+	err    error
+	nextID int
+	subs   map[int]func(T)
+	once   sync.Once
+}
+
+// NewValue loads the initial value and starts watching for changes
+func NewValue[T any](l Loader) (*Value[T], error) {
+	val, err := LoadAs[T](l)
+	if err != nil {
+		return nil, err
+	}
+	res := &Value[T]{
+		l:    l,
+		val:  val,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+		subs: make(map[int]func(T)),
+	}
+	go res.watch()
+	return res, nil
+}
+
+func (v *Value[T]) watch() {
+	defer close(v.done)
+	changes := v.l.Changes()
+	if changes == nil {
+		<-v.stop
+		return
+	}
+	for {
+		select {
+		case <-v.stop:
+			return
+		case _, ok := <-changes:
+			if !ok {
+				return
+			}
+			v.reload()
+		}
+	}
+}
+
+func (v *Value[T]) reload() {
+	val, err := LoadAs[T](v.l)
+	v.lock.Lock()
+	v.err = err
+	if err != nil {
+		v.lock.Unlock()
+		return
+	}
+	v.val = val
+	subs := make([]func(T), 0, len(v.subs))
+	for _, f := range v.subs {
+		subs = append(subs, f)
+	}
+	v.lock.Unlock()
+	for _, f := range subs {
+		f(val)
+	}
+}
+
+// Get returns the current value
+func (v *Value[T]) Get() T {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	return v.val
+}
+
+// Err returns the error of the last reload, if it failed. The value is kept
+// from the last successful one in that case.
+func (v *Value[T]) Err() error {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	return v.err
+}
+
+// Subscribe calls the function with every new value from the watching
+// goroutine. The returned function cancels the subscription.
+func (v *Value[T]) Subscribe(f func(T)) (cancel func()) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	id := v.nextID
+	v.nextID++
+	v.subs[id] = f
+	return func() {
+		v.lock.Lock()
+		defer v.lock.Unlock()
+		delete(v.subs, id)
+	}
+}
+
+// Close stops watching for changes. The loader is not closed.
+func (v *Value[T]) Close() error {
+	v.once.Do(func() { close(v.stop) })
+	<-v.done
+	return nil
+}
@@ -0,0 +1,72 @@
+//go:build go1.18
+
+package loader_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/loadertest"
+)
+
+type testConfig struct {
+	Name string
+	Port int
+}
+
+func TestLoadAs(t *testing.T) {
+	cfg, err := loader.LoadAs[testConfig](loadertest.NewFake("name: x\nport: 80\n"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if cfg != (testConfig{"x", 80}) {
+		t.Errorf("invalid result %+v", cfg)
+	}
+}
+
+func TestValue(t *testing.T) {
+	f := loadertest.NewFake("name: x\nport: 80\n")
+	v, err := loader.NewValue[testConfig](f)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer v.Close()
+	if cfg := v.Get(); cfg != (testConfig{"x", 80}) {
+		t.Errorf("invalid result %+v", cfg)
+	}
+	updates := make(chan testConfig, 1)
+	cancel := v.Subscribe(func(cfg testConfig) { updates <- cfg })
+	f.Set("port", 81)
+	select {
+	case cfg := <-updates:
+		if cfg != (testConfig{"x", 81}) || v.Get() != cfg {
+			t.Errorf("invalid update %+v", cfg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for update")
+	}
+	f.FailNext(1, errors.New("unavailable"))
+	f.Notify()
+	for start := time.Now(); v.Err() == nil; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("timed out waiting for error")
+		}
+	}
+	if cfg := v.Get(); cfg.Port != 81 {
+		t.Errorf("value must be kept on error: %+v", cfg)
+	}
+	cancel()
+	f.Set("port", 82)
+	for start := time.Now(); v.Get().Port != 82; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("timed out waiting for reload")
+		}
+	}
+	select {
+	case cfg := <-updates:
+		t.Errorf("cancelled subscription got %+v", cfg)
+	default:
+	}
+}
@@ -0,0 +1,281 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/file"
+	"github.com/go-mixins/loader/internal/tlsutil"
+)
+
+// Defaults for Config
+var (
+	DefaultInterval = 30 * time.Second
+	DefaultTimeout  = 30 * time.Second
+)
+
+// Config specifies the optional HTTP loader settings
+type Config struct {
+	// Client to make requests with. Built from TLS settings if not
+	// specified.
+	Client *http.Client
+	// Format of the document. If not specified, the format is chosen by
+	// the response Content-Type, then by URL path extension.
+	Format file.UnmarshalFunc
+	// Interval between polling requests. When LongPoll is set, Interval
+	// is the delay between retries after errors.
+	Interval time.Duration
+	// LongPoll is set for the servers that hold conditional request until
+	// the document changes. The next request is made right after the
+	// previous one returns.
+	LongPoll bool
+	// Timeout of a request, DefaultTimeout if zero. The long polling
+	// requests are not limited by it.
+	Timeout time.Duration
+	// Token is sent as "Authorization: Bearer" header
+	Token string
+	// Header is added to every request
+	Header http.Header
+	// TLS client certificate and CA certificate files
+	CertFile, KeyFile, CACertFile string
+}
+
+// Loader implements loader.Loader for a document fetched by URL
+type Loader struct {
+	url     string
+	cfg     Config
+	changes chan struct{}
+	stop    context.CancelFunc
+	done    chan struct{}
+	err     error
+
+	lock         sync.Mutex
+	etag         string
+	lastModified string
+	contentType  string
+	data         []byte
+	sum          [sha256.Size]byte
+	onError      func(error)
+}
+
+var _ loader.Loader = (*Loader)(nil)
+
+// New creates Loader for the URL and starts polling it. The config may be
+// nil.
+func New(url string, cfg *Config) (res *Loader) {
+	res = &Loader{
+		url:     url,
+		changes: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if cfg != nil {
+		res.cfg = *cfg
+	}
+	if res.cfg.Interval <= 0 {
+		res.cfg.Interval = DefaultInterval
+	}
+	if res.cfg.Timeout <= 0 {
+		res.cfg.Timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	res.stop = cancel
+	if res.cfg.Client == nil {
+		if res.cfg.Client, res.err = newClient(&res.cfg); res.err != nil {
+			close(res.changes)
+			close(res.done)
+			return
+		}
+	}
+	go res.poll(ctx)
+	return
+}
+
+func newClient(cfg *Config) (*http.Client, error) {
+	tlsConfig, err := tlsutil.Config(cfg.CertFile, cfg.KeyFile, cfg.CACertFile)
+	if err != nil || tlsConfig == nil {
+		return &http.Client{}, err
+	}
+	return &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}}, nil
+}
+
+// Close stops polling and closes changes channel
+func (l *Loader) Close() error {
+	l.stop()
+	<-l.done
+	return nil
+}
+
+// Changes provides source of config change events. The event is emitted
+// only when the document content changes.
+func (l *Loader) Changes() <-chan struct{} {
+	return l.changes
+}
+
+// WithPollError sets the handler of polling errors and returns the Loader.
+// The handler is called from the polling goroutine when the document can't
+// be fetched or parsed.
+func (l *Loader) WithPollError(handler func(error)) *Loader {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.onError = handler
+	return l
+}
+
+// Load fetches the document and decodes it into the target object
+func (l *Loader) Load(dest interface{}) error {
+	if l.err != nil {
+		return l.err
+	}
+	// Long polling server would hold conditional request until the next
+	// change, so the document is requested unconditionally
+	ctx, cancel := context.WithTimeout(context.Background(), l.cfg.Timeout)
+	defer cancel()
+	if _, err := l.fetch(ctx, !l.cfg.LongPoll); err != nil {
+		return err
+	}
+	l.lock.Lock()
+	data, contentType := l.data, l.contentType
+	l.lock.Unlock()
+	return loader.DecodeErrors.Wrap(l.format(contentType)(data, dest), "unmarshal data")
+}
+
+func (l *Loader) format(contentType string) file.UnmarshalFunc {
+	if l.cfg.Format != nil {
+		return l.cfg.Format
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasSuffix(mediaType, "json"):
+		return json.Unmarshal
+	case strings.HasSuffix(mediaType, "yaml"):
+		return yaml.Unmarshal
+	}
+	path := l.url
+	if u, err := url.Parse(l.url); err == nil {
+		path = u.Path
+	}
+	return file.Format(path)
+}
+
+// fetch requests and stores the document. It reports whether the content has
+// changed since the previous fetch.
+func (l *Loader) fetch(ctx context.Context, conditional bool) (changed bool, err error) {
+	req, err := http.NewRequest(http.MethodGet, l.url, nil)
+	if err != nil {
+		return false, loader.Errors.Wrap(err, "creating request")
+	}
+	req = req.WithContext(ctx)
+	for k, v := range l.cfg.Header {
+		req.Header[k] = v
+	}
+	if l.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+l.cfg.Token)
+	}
+	l.lock.Lock()
+	if conditional && l.data != nil {
+		if l.etag != "" {
+			req.Header.Set("If-None-Match", l.etag)
+		}
+		if l.lastModified != "" {
+			req.Header.Set("If-Modified-Since", l.lastModified)
+		}
+	}
+	l.lock.Unlock()
+	resp, err := l.cfg.Client.Do(req)
+	if err != nil {
+		return false, loader.Errors.Wrapf(err, "fetching %q", l.url)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, loader.Errors.Errorf("fetching %q: %s", l.url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, loader.Errors.Wrapf(err, "reading %q", l.url)
+	}
+	sum := sha256.Sum256(data)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	changed = l.data != nil && !bytes.Equal(sum[:], l.sum[:])
+	l.data, l.sum = data, sum
+	l.etag = resp.Header.Get("ETag")
+	l.lastModified = resp.Header.Get("Last-Modified")
+	l.contentType = resp.Header.Get("Content-Type")
+	return changed, nil
+}
+
+// pollOnce makes single polling request
+func (l *Loader) pollOnce(ctx context.Context) (bool, error) {
+	if !l.cfg.LongPoll {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.cfg.Timeout)
+		defer cancel()
+	}
+	return l.fetch(ctx, true)
+}
+
+func (l *Loader) poll(ctx context.Context) {
+	defer close(l.done)
+	defer close(l.changes)
+	for {
+		delay := l.cfg.Interval
+		changed, err := l.pollOnce(ctx)
+		if err == nil && changed {
+			err = l.parse()
+		}
+		if err != nil && ctx.Err() == nil {
+			l.pollError(err)
+		}
+		if err == nil && l.cfg.LongPoll {
+			delay = 0
+		}
+		if changed {
+			select {
+			case l.changes <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// parse checks that the fetched document can be parsed
+func (l *Loader) parse() error {
+	l.lock.Lock()
+	data, contentType := l.data, l.contentType
+	l.lock.Unlock()
+	var tree interface{}
+	return loader.DecodeErrors.Wrapf(l.format(contentType)(data, &tree), "parsing %q", l.url)
+}
+
+func (l *Loader) pollError(err error) {
+	l.lock.Lock()
+	handler := l.onError
+	l.lock.Unlock()
+	if handler != nil {
+		handler(err)
+	}
+}
@@ -0,0 +1,215 @@
+package http_test
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	loaderhttp "github.com/go-mixins/loader/http"
+)
+
+type document struct {
+	sync.Mutex
+	body    string
+	version int
+	hits    int
+}
+
+func (d *document) set(body string, version int) {
+	d.Lock()
+	defer d.Unlock()
+	d.body, d.version = body, version
+}
+
+func (d *document) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.Lock()
+	defer d.Unlock()
+	d.hits++
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	etag := fmt.Sprintf(`"%d"`, d.version)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Type", "application/x-yaml")
+	fmt.Fprint(w, d.body)
+}
+
+type testConfig struct {
+	A string
+	B int
+}
+
+func TestLoader(t *testing.T) {
+	doc := &document{body: "a: x\nb: 1\n"}
+	srv := httptest.NewTLSServer(doc)
+	defer srv.Close()
+	ca, err := ioutil.TempFile("", "loader")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer os.Remove(ca.Name())
+	pem.Encode(ca, &pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	ca.Close()
+
+	unauthorized := loaderhttp.New(srv.URL, &loaderhttp.Config{Client: srv.Client()})
+	if err := unauthorized.Load(&testConfig{}); err == nil {
+		t.Error("expecting error without token")
+	}
+	unauthorized.Close()
+
+	l := loaderhttp.New(srv.URL, &loaderhttp.Config{
+		Token:      "token",
+		CACertFile: ca.Name(),
+		Interval:   50 * time.Millisecond,
+	})
+	defer l.Close()
+	var dest testConfig
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dest != (testConfig{"x", 1}) {
+		t.Errorf("invalid result %+v", dest)
+	}
+	doc.set("a: x\nb: 1\n", 1)
+	select {
+	case <-l.Changes():
+		t.Error("same content must not emit change")
+	case <-time.After(200 * time.Millisecond):
+	}
+	doc.set("a: y\nb: 2\n", 2)
+	select {
+	case <-l.Changes():
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for change")
+	}
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dest != (testConfig{"y", 2}) {
+		t.Errorf("invalid result %+v", dest)
+	}
+}
+
+func TestLoader_LongPoll(t *testing.T) {
+	var (
+		lock    sync.Mutex
+		version = 1
+		updated = make(chan struct{})
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		etag := fmt.Sprintf(`"%d"`, version)
+		lock.Unlock()
+		if r.Header.Get("If-None-Match") == etag {
+			select {
+			case <-updated:
+			case <-r.Context().Done():
+				return
+			}
+			lock.Lock()
+			version++
+			etag = fmt.Sprintf(`"%d"`, version)
+			lock.Unlock()
+		}
+		w.Header().Set("ETag", etag)
+		lock.Lock()
+		fmt.Fprintf(w, `{"B": %d}`, version)
+		lock.Unlock()
+	}))
+	defer srv.Close()
+	l := loaderhttp.New(srv.URL+"/config.json", &loaderhttp.Config{LongPoll: true})
+	defer l.Close()
+	var dest testConfig
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	updated <- struct{}{}
+	select {
+	case <-l.Changes():
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for change")
+	}
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dest.B != 2 {
+		t.Errorf("invalid result %+v", dest)
+	}
+}
+
+func TestLoader_PollError(t *testing.T) {
+	broken := loaderhttp.New("http://localhost/", &loaderhttp.Config{CACertFile: "missing.pem"})
+	if err := broken.Load(&testConfig{}); err == nil {
+		t.Error("expecting error with missing CA file")
+	}
+	select {
+	case _, ok := <-broken.Changes():
+		if ok {
+			t.Error("unexpected change")
+		}
+	case <-time.After(time.Second):
+		t.Error("changes channel must be closed")
+	}
+	broken.Close()
+
+	doc := &document{body: "a: x\n"}
+	srv := httptest.NewServer(doc)
+	defer srv.Close()
+	errs := make(chan error, 10)
+	l := loaderhttp.New(srv.URL, &loaderhttp.Config{
+		Token:    "token",
+		Interval: 20 * time.Millisecond,
+	}).WithPollError(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+	defer l.Close()
+	if err := l.Load(&testConfig{}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	doc.set("a: [x\n", 1)
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "parsing") {
+			t.Errorf("expecting parse error, got %+v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for poll error")
+	}
+}
+
+func TestLoader_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+	l := loaderhttp.New(srv.URL, &loaderhttp.Config{Timeout: 50 * time.Millisecond})
+	defer l.Close()
+	start := time.Now()
+	if err := l.Load(&testConfig{}); err == nil {
+		t.Error("expecting timeout")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Load took %v", d)
+	}
+}
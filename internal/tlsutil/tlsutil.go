@@ -0,0 +1,37 @@
+// Package tlsutil builds TLS client settings shared by network loaders
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+
+	"github.com/go-mixins/loader"
+)
+
+// Config loads client certificate and CA certificate files. It returns nil if
+// neither certFile nor caCertFile is specified.
+func Config(certFile, keyFile, caCertFile string) (*tls.Config, error) {
+	if certFile == "" && caCertFile == "" {
+		return nil, nil
+	}
+	res := new(tls.Config)
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, loader.Errors.Wrap(err, "loading client certificate")
+		}
+		res.Certificates = []tls.Certificate{cert}
+	}
+	if caCertFile != "" {
+		pem, err := ioutil.ReadFile(caCertFile)
+		if err != nil {
+			return nil, loader.Errors.Wrap(err, "reading CA certificate")
+		}
+		res.RootCAs = x509.NewCertPool()
+		if !res.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, loader.Errors.Errorf("no certificates found in %q", caCertFile)
+		}
+	}
+	return res, nil
+}
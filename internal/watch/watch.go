@@ -0,0 +1,149 @@
+// Package watch reports debounced file system events as change notifications
+package watch
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-fsnotify/fsnotify"
+
+	"github.com/go-mixins/loader"
+)
+
+// Watcher runs the watching process for a set of files and directories
+type Watcher struct {
+	stop, changes chan struct{}
+	result        chan error
+	fs            *fsnotify.Watcher
+	debounce      *int
+	match         func(name string) bool
+	lock          sync.Mutex
+	extra         map[string]bool
+	closeOnce     sync.Once
+}
+
+// New starts the watching process. The events coming within debounce
+// milliseconds from each other are reported once. Only events for the names
+// accepted by match are reported, nil match accepts everything. Even if the
+// error is returned, the Watcher is usable, although it never reports any
+// changes.
+func New(debounce *int, match func(name string) bool) (res *Watcher, err error) {
+	res = &Watcher{
+		stop:     make(chan struct{}),
+		changes:  make(chan struct{}, 1),
+		result:   make(chan error, 1),
+		debounce: debounce,
+		match:    match,
+	}
+	if res.fs, err = fsnotify.NewWatcher(); err != nil {
+		close(res.result)
+		err = loader.Errors.Wrap(err, "creating fsnotify watcher")
+		return
+	}
+	go func() {
+		defer close(res.changes)
+		defer func() {
+			res.result <- res.fs.Close()
+			close(res.result)
+		}()
+		for {
+			select {
+			case <-res.stop:
+				return
+			case <-res.fs.Errors:
+			case ev := <-res.fs.Events:
+				if !res.accept(ev) {
+					break
+				}
+			loop:
+				for {
+					// This loop will consume consecutive change events that
+					// will come during debounce timeout. Only the last
+					// one will be reported, after the timeout expires.
+					select {
+					case <-res.stop:
+						return
+					case <-res.fs.Errors:
+					case <-res.fs.Events:
+						break
+					case <-time.After(time.Duration(*res.debounce) * time.Millisecond):
+						break loop
+					}
+				}
+				select {
+				case res.changes <- struct{}{}:
+				case <-res.stop:
+					return
+				}
+			}
+		}
+	}()
+	return
+}
+
+func (w *Watcher) accept(ev fsnotify.Event) bool {
+	if w.match == nil || w.match(ev.Name) {
+		return true
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.extra[ev.Name] || w.extra[filepath.Dir(ev.Name)]
+}
+
+// Add puts the name under watch
+func (w *Watcher) Add(name string) error {
+	if w.fs == nil {
+		return nil
+	}
+	return loader.Errors.Wrapf(w.fs.Add(name), "adding %q to watcher", name)
+}
+
+// SetExtra puts the names under watch in addition to the ones passed to Add,
+// releasing the extra names from previous call that are not needed anymore.
+// Events for the extra names and for the entries of extra directories are
+// reported regardless of the match function.
+func (w *Watcher) SetExtra(names []string) (err error) {
+	if w.fs == nil {
+		return
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	extra := make(map[string]bool, len(names))
+	for _, name := range names {
+		if !w.extra[name] {
+			if e := w.fs.Add(name); e != nil {
+				if err == nil {
+					err = loader.Errors.Wrapf(e, "adding %q to watcher", name)
+				}
+				continue
+			}
+		}
+		extra[name] = true
+	}
+	for name := range w.extra {
+		if !extra[name] {
+			w.fs.Remove(name)
+		}
+	}
+	w.extra = extra
+	return
+}
+
+// Changes provides source of change events
+func (w *Watcher) Changes() <-chan struct{} {
+	return w.changes
+}
+
+// Close stops the watching process and returns the result of closing
+// underlying fsnotify watcher. Subsequent calls return nil.
+func (w *Watcher) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.stop)
+		if w.fs == nil {
+			// there is no watching process to close the channel
+			close(w.changes)
+		}
+	})
+	return loader.Errors.Wrap(<-w.result, "closing watcher")
+}
@@ -0,0 +1,46 @@
+package boltdb
+
+import (
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libkv/store/boltdb"
+
+	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/libkv"
+)
+
+// Loader implements BoltDB Loader. BoltDB does not support watching, so
+// the changes are never reported.
+type Loader struct {
+	*libkv.Loader
+	err error
+}
+
+// New creates BoltDB loader initialized with specific prefix, bucket and
+// database file name. The file is opened only for the time of loading, so
+// other processes may update it.
+func New(prefix, bucket, path string) (res *Loader) {
+	res = new(Loader)
+	kv, err := boltdb.New(
+		[]string{path},
+		&store.Config{
+			Bucket:            bucket,
+			ConnectionTimeout: 10 * time.Second,
+		},
+	)
+	if err != nil {
+		res.err = loader.Errors.Wrap(err, "creating BoltDB source")
+		return
+	}
+	res.Loader, res.err = libkv.New(prefix, kv)
+	return
+}
+
+// Load loads the target from BoltDB source
+func (l *Loader) Load(dest interface{}) error {
+	if l.err != nil {
+		return l.err
+	}
+	return l.Loader.Load(dest)
+}
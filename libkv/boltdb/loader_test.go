@@ -0,0 +1,31 @@
+package boltdb_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/libkv/store"
+	libkvboltdb "github.com/docker/libkv/store/boltdb"
+
+	"github.com/go-mixins/loader/libkv/boltdb"
+	"github.com/go-mixins/loader/libkv/internal/kvtest"
+)
+
+func TestLoader(t *testing.T) {
+	td, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer os.RemoveAll(td)
+	path := filepath.Join(td, "config.db")
+	kvtest.Run(t, kvtest.Backend{
+		Store: func() (store.Store, error) {
+			return libkvboltdb.New([]string{path}, &store.Config{Bucket: "test"})
+		},
+		Loader: func(prefix string) kvtest.Loader {
+			return boltdb.New(prefix, "test", path)
+		},
+	})
+}
@@ -0,0 +1,91 @@
+package libkv_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/docker/libkv/store"
+
+	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/libkv"
+	"github.com/go-mixins/loader/loadertest"
+)
+
+// kvWatchable notifies the watchers about every write
+type kvWatchable struct {
+	*kvMap
+	lock     sync.Mutex
+	watchers map[chan []*store.KVPair]bool
+}
+
+func (kvm *kvWatchable) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	kvm.lock.Lock()
+	defer kvm.lock.Unlock()
+	c := make(chan []*store.KVPair, 1)
+	kvm.watchers[c] = true
+	go func() {
+		<-stopCh
+		kvm.lock.Lock()
+		defer kvm.lock.Unlock()
+		delete(kvm.watchers, c)
+		close(c)
+	}()
+	return c, nil
+}
+
+func (kvm *kvWatchable) notify() {
+	for c := range kvm.watchers {
+		select {
+		case c <- nil:
+		default:
+		}
+	}
+}
+
+func (kvm *kvWatchable) Put(key string, value []byte, options *store.WriteOptions) error {
+	kvm.lock.Lock()
+	defer kvm.lock.Unlock()
+	defer kvm.notify()
+	return kvm.kvMap.Put(key, value, options)
+}
+
+func (kvm *kvWatchable) Delete(key string) error {
+	kvm.lock.Lock()
+	defer kvm.lock.Unlock()
+	defer kvm.notify()
+	return kvm.kvMap.Delete(key)
+}
+
+func (kvm *kvWatchable) Get(key string) (*store.KVPair, error) {
+	kvm.lock.Lock()
+	defer kvm.lock.Unlock()
+	return kvm.kvMap.Get(key)
+}
+
+func (kvm *kvWatchable) List(directory string) ([]*store.KVPair, error) {
+	kvm.lock.Lock()
+	defer kvm.lock.Unlock()
+	return kvm.kvMap.List(directory)
+}
+
+func TestConformance(t *testing.T) {
+	loadertest.Run(t, func(t *testing.T) loadertest.Subject {
+		kv := &kvWatchable{
+			kvMap:    &kvMap{pairs: make(map[string]*store.KVPair)},
+			watchers: make(map[chan []*store.KVPair]bool),
+		}
+		return loadertest.Subject{
+			Store: func(src interface{}) error {
+				return libkv.Save(kv, "app", src, &libkv.SaveOptions{Prune: true})
+			},
+			New: func() loader.Loader {
+				l, err := libkv.New("app", kv)
+				if err != nil {
+					t.Fatalf("%+v", err)
+				}
+				return l
+			},
+			Watch: true,
+		}
+	})
+}
@@ -0,0 +1,290 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libkv/store"
+
+	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/internal/tlsutil"
+)
+
+// Environment variables consulted when Config leaves the settings empty
+const (
+	AddrEnv  = "CONSUL_HTTP_ADDR"
+	TokenEnv = "CONSUL_HTTP_TOKEN"
+)
+
+// Defaults for Config
+var (
+	DefaultAddress  = "127.0.0.1:8500"
+	DefaultTimeout  = 10 * time.Second
+	DefaultWaitTime = 5 * time.Minute
+)
+
+// Config specifies Consul connection settings
+type Config struct {
+	// Address of Consul agent, like "consul:8500" or "https://consul:8501".
+	// Taken from CONSUL_HTTP_ADDR if empty, then DefaultAddress. HTTPS is
+	// used by default if TLS certificates are specified.
+	Address string
+	// Datacenter and Namespace to query, the agent's ones if empty
+	Datacenter, Namespace string
+	// ACL Token. If empty, it is read from TokenFile, then taken from
+	// CONSUL_HTTP_TOKEN.
+	Token, TokenFile string
+	// TLS client certificate and CA certificate files
+	CertFile, KeyFile, CACertFile string
+	// Timeout of a single request, DefaultTimeout if zero
+	Timeout time.Duration
+	// Consistent makes the reads go through the leader, so that they never
+	// return stale data
+	Consistent bool
+	// WaitTime of blocking queries used to watch for changes,
+	// DefaultWaitTime if zero
+	WaitTime time.Duration
+	// Client to make requests with. Built from TLS settings if not
+	// specified.
+	Client *http.Client
+}
+
+// client implements the part of libkv store.Store used by the loader with
+// Consul HTTP API
+type client struct {
+	cfg     Config
+	base    *url.URL
+	token   string
+	timeout time.Duration
+	wait    time.Duration
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	lock  sync.Mutex
+	index uint64
+}
+
+type kvPair struct {
+	Key         string
+	Value       []byte
+	ModifyIndex uint64
+}
+
+func newClient(cfg *Config) (*client, error) {
+	res := &client{cfg: *cfg, timeout: cfg.Timeout, wait: cfg.WaitTime}
+	if res.timeout <= 0 {
+		res.timeout = DefaultTimeout
+	}
+	if res.wait <= 0 {
+		res.wait = DefaultWaitTime
+	}
+	tlsConfig, err := tlsutil.Config(cfg.CertFile, cfg.KeyFile, cfg.CACertFile)
+	if err != nil {
+		return nil, err
+	}
+	if res.cfg.Client == nil {
+		res.cfg.Client = &http.Client{}
+		if tlsConfig != nil {
+			res.cfg.Client.Transport = &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			}
+		}
+	}
+	addr := cfg.Address
+	if addr == "" {
+		addr = os.Getenv(AddrEnv)
+	}
+	if addr == "" {
+		addr = DefaultAddress
+	}
+	if !strings.Contains(addr, "://") {
+		if tlsConfig != nil {
+			addr = "https://" + addr
+		} else {
+			addr = "http://" + addr
+		}
+	}
+	if res.base, err = url.Parse(addr); err != nil {
+		return nil, loader.Errors.Wrap(err, "parsing Consul address")
+	}
+	switch {
+	case cfg.Token != "":
+		res.token = cfg.Token
+	case cfg.TokenFile != "":
+		data, err := ioutil.ReadFile(cfg.TokenFile)
+		if err != nil {
+			return nil, loader.Errors.Wrap(err, "reading Consul token")
+		}
+		res.token = strings.TrimSpace(string(data))
+	default:
+		res.token = os.Getenv(TokenEnv)
+	}
+	res.ctx, res.cancel = context.WithCancel(context.Background())
+	return res, nil
+}
+
+// query requests the key, recursively if asked. The index is used for
+// blocking queries if non-zero. It returns store.ErrKeyNotFound if there are
+// no keys.
+func (c *client) query(ctx context.Context, key string, recurse bool, index uint64) ([]kvPair, uint64, error) {
+	u := *c.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/v1/kv/" + strings.TrimLeft(key, "/")
+	q := url.Values{}
+	if c.cfg.Datacenter != "" {
+		q.Set("dc", c.cfg.Datacenter)
+	}
+	if c.cfg.Namespace != "" {
+		q.Set("ns", c.cfg.Namespace)
+	}
+	if recurse {
+		q.Set("recurse", "")
+	}
+	if c.cfg.Consistent && index == 0 {
+		q.Set("consistent", "")
+	}
+	timeout := c.timeout
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", c.wait.String())
+		// Consul adds up to wait/16 of random jitter to the wait time
+		timeout += c.wait + c.wait/16
+	}
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, 0, loader.Errors.Wrap(err, "creating request")
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	resp, err := c.cfg.Client.Do(req)
+	if err != nil {
+		return nil, 0, loader.Errors.Wrapf(err, "querying %q", key)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNotFound:
+	default:
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, 0, loader.Errors.Errorf("querying %q: %s: %s", key, resp.Status, strings.TrimSpace(string(body)))
+	}
+	// Blocking query with no valid index would return at once, so the watch
+	// must not go on without it
+	header := resp.Header.Get("X-Consul-Index")
+	newIndex, err := strconv.ParseUint(header, 10, 64)
+	if err != nil || newIndex == 0 {
+		return nil, 0, loader.Errors.Errorf("querying %q: invalid X-Consul-Index %q", key, header)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, newIndex, store.ErrKeyNotFound
+	}
+	var res []kvPair
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, 0, loader.Errors.Wrapf(err, "decoding %q", key)
+	}
+	return res, newIndex, nil
+}
+
+func convert(pairs []kvPair) []*store.KVPair {
+	res := make([]*store.KVPair, len(pairs))
+	for i, p := range pairs {
+		res[i] = &store.KVPair{Key: p.Key, Value: p.Value, LastIndex: p.ModifyIndex}
+	}
+	return res
+}
+
+func (c *client) Get(key string) (*store.KVPair, error) {
+	pairs, _, err := c.query(c.ctx, key, false, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(pairs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	return convert(pairs)[0], nil
+}
+
+// List reads the whole directory at a single index, which is remembered as
+// the version of the data
+func (c *client) List(directory string) ([]*store.KVPair, error) {
+	pairs, index, err := c.query(c.ctx, directory, true, 0)
+	if err != nil && err != store.ErrKeyNotFound {
+		return nil, err
+	}
+	c.lock.Lock()
+	c.index = index
+	c.lock.Unlock()
+	return convert(pairs), err
+}
+
+func (c *client) version() uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.index
+}
+
+// WatchTree sends the pairs under directory each time they change, starting
+// with the current ones. The channel is closed on error.
+func (c *client) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	pairs, index, err := c.query(c.ctx, directory, true, 0)
+	if err != nil && err != store.ErrKeyNotFound {
+		return nil, err
+	}
+	index = nextIndex(0, index)
+	res := make(chan []*store.KVPair, 1)
+	res <- convert(pairs)
+	ctx, cancel := context.WithCancel(c.ctx)
+	go func() {
+		defer cancel()
+		select {
+		case <-stopCh:
+		case <-ctx.Done():
+		}
+	}()
+	go func() {
+		defer close(res)
+		defer cancel()
+		for {
+			pairs, newIndex, err := c.query(ctx, directory, true, index)
+			if err != nil && err != store.ErrKeyNotFound {
+				return
+			}
+			if newIndex == index {
+				continue
+			}
+			index = nextIndex(index, newIndex)
+			select {
+			case res <- convert(pairs):
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return res, nil
+}
+
+// nextIndex follows the recommendations on blocking queries: the index is
+// reset if it goes backwards, like after snapshot restore, and is kept
+// positive
+func nextIndex(prev, index uint64) uint64 {
+	if index < prev || index == 0 {
+		return 1
+	}
+	return index
+}
+
+func (c *client) Close() {
+	c.cancel()
+}
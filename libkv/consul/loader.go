@@ -1,11 +1,6 @@
 package consul
 
 import (
-	"time"
-
-	"github.com/docker/libkv/store"
-	"github.com/docker/libkv/store/consul"
-
 	"github.com/go-mixins/loader"
 	"github.com/go-mixins/loader/libkv"
 )
@@ -13,26 +8,48 @@ import (
 // Loader implements Consul Loader
 type Loader struct {
 	*libkv.Loader
-	err error
+	client *client
+	err    error
+}
+
+// New creates Consul loader initialized with specific prefix and endpoints.
+// Only the first endpoint is used, the default Config settings apply
+// otherwise.
+func New(prefix string, endpoints ...string) *Loader {
+	cfg := new(Config)
+	if len(endpoints) > 0 {
+		cfg.Address = endpoints[0]
+	}
+	return NewWithConfig(prefix, cfg)
 }
 
-// New creates Consul loader initialized with specific prefix and endpoints
-func New(prefix string, endpoints ...string) (res *Loader) {
+// NewWithConfig creates Consul loader initialized with specific prefix and
+// connection settings. The config may be nil.
+func NewWithConfig(prefix string, cfg *Config) (res *Loader) {
 	res = new(Loader)
-	kv, err := consul.New(
-		endpoints,
-		&store.Config{
-			ConnectionTimeout: 10 * time.Second,
-		},
-	)
+	if cfg == nil {
+		cfg = new(Config)
+	}
+	kv, err := newClient(cfg)
 	if err != nil {
 		res.err = loader.Errors.Wrap(err, "creating Consul source")
 		return
 	}
+	res.client = kv
 	res.Loader, res.err = libkv.New(prefix, kv)
 	return
 }
 
+// Version returns Consul index the data was read at by the last Load. The
+// whole prefix is read in single request, so that the data is consistent
+// with that index. It is 0 if the loader failed to initialize.
+func (l *Loader) Version() uint64 {
+	if l.client == nil {
+		return 0
+	}
+	return l.client.version()
+}
+
 // Load loads the target from Consul source
 func (l *Loader) Load(dest interface{}) error {
 	if l.err != nil {
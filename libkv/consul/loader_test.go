@@ -0,0 +1,237 @@
+package consul_test
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-mixins/loader/libkv/consul"
+)
+
+// fakeConsul serves KV part of Consul HTTP API, including blocking queries
+type fakeConsul struct {
+	sync.Mutex
+	token, dc string
+	index     uint64
+	keys      map[string]string
+	reads     []url.Values
+	changed   chan struct{}
+	noIndex   bool
+}
+
+func newFakeConsul(token, dc string) *fakeConsul {
+	return &fakeConsul{
+		token:   token,
+		dc:      dc,
+		index:   1,
+		keys:    make(map[string]string),
+		changed: make(chan struct{}),
+	}
+}
+
+func (f *fakeConsul) set(key, value string) {
+	f.Lock()
+	defer f.Unlock()
+	f.index++
+	f.keys[key] = value
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Consul-Token") != f.token {
+		http.Error(w, "ACL not found", http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("dc") != f.dc {
+		http.Error(w, "No path to datacenter", http.StatusInternalServerError)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	f.Lock()
+	f.reads = append(f.reads, q)
+	if index, _ := strconv.ParseUint(q.Get("index"), 10, 64); index >= f.index {
+		wait, _ := time.ParseDuration(q.Get("wait"))
+		changed := f.changed
+		f.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(wait):
+		case <-r.Context().Done():
+		}
+		f.Lock()
+	}
+	defer f.Unlock()
+	type kvPair struct {
+		Key         string
+		Value       []byte
+		ModifyIndex uint64
+	}
+	var res []kvPair
+	for k, v := range f.keys {
+		if k == key || (q["recurse"] != nil && strings.HasPrefix(k, key)) {
+			res = append(res, kvPair{k, []byte(v), f.index})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	if !f.noIndex {
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	}
+	if len(res) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+type testConfig struct {
+	Name string
+	DB   struct {
+		Port int
+	}
+}
+
+func tempFile(t *testing.T, data []byte) string {
+	f, err := ioutil.TempFile("", "consul")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer f.Close()
+	if _, err = f.Write(data); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return f.Name()
+}
+
+func TestNewWithConfig(t *testing.T) {
+	fake := newFakeConsul("secret", "dc2")
+	fake.set("app/name", "x")
+	fake.set("app/db/port", "5432")
+	srv := httptest.NewTLSServer(fake)
+	defer srv.Close()
+	ca := tempFile(t, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+	defer os.Remove(ca)
+	tokenFile := tempFile(t, []byte("secret\n"))
+	defer os.Remove(tokenFile)
+
+	os.Setenv(consul.TokenEnv, "wrong")
+	defer os.Unsetenv(consul.TokenEnv)
+	unauthorized := consul.NewWithConfig("app", &consul.Config{
+		Address:    srv.URL,
+		Datacenter: "dc2",
+		Client:     srv.Client(),
+	})
+	if err := unauthorized.Load(&testConfig{}); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expecting ACL error, got %v", err)
+	}
+	unauthorized.Close()
+
+	l := consul.NewWithConfig("app", &consul.Config{
+		Address:    strings.TrimPrefix(srv.URL, "https://"),
+		Datacenter: "dc2",
+		TokenFile:  tokenFile,
+		CACertFile: ca,
+		WaitTime:   time.Second,
+		Consistent: true,
+	})
+	defer l.Close()
+	var dest testConfig
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dest.Name != "x" || dest.DB.Port != 5432 {
+		t.Errorf("invalid result %+v", dest)
+	}
+	fake.Lock()
+	for _, q := range fake.reads {
+		if _, blocking := q["index"]; !blocking && q["consistent"] == nil {
+			t.Errorf("expecting consistent read: %v", q)
+		}
+	}
+	index := fake.index
+	fake.Unlock()
+	if v := l.Version(); v != index {
+		t.Errorf("expecting version %d, got %d", index, v)
+	}
+	// The current state is reported when the watch is established
+	select {
+	case <-l.Changes():
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for initial change")
+	}
+	fake.set("app/name", "y")
+	select {
+	case <-l.Changes():
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for change")
+	}
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dest.Name != "y" || l.Version() != index+1 {
+		t.Errorf("invalid result %+v at version %d", dest, l.Version())
+	}
+}
+
+func TestNew(t *testing.T) {
+	fake := newFakeConsul("", "")
+	fake.set("app/name", "x")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	l := consul.New("app", strings.TrimPrefix(srv.URL, "http://"))
+	defer l.Close()
+	var dest testConfig
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	fake.Lock()
+	index := fake.index
+	fake.Unlock()
+	if dest.Name != "x" || l.Version() != index {
+		t.Errorf("invalid result %+v at version %d", dest, l.Version())
+	}
+}
+
+func TestNew_NoIndex(t *testing.T) {
+	fake := newFakeConsul("", "")
+	fake.set("app/name", "x")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	l := consul.New("app", strings.TrimPrefix(srv.URL, "http://"))
+	defer l.Close()
+	select {
+	case <-l.Changes():
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for initial change")
+	}
+	fake.Lock()
+	fake.noIndex = true
+	fake.Unlock()
+	fake.set("app/name", "y")
+	time.Sleep(200 * time.Millisecond)
+	fake.Lock()
+	reads := len(fake.reads)
+	fake.Unlock()
+	// The watch is re-established with backoff instead of the tight loop
+	if reads > 10 {
+		t.Errorf("too many reads: %d", reads)
+	}
+	if err := l.Load(&testConfig{}); err == nil || !strings.Contains(err.Error(), "X-Consul-Index") {
+		t.Errorf("expecting index error, got %v", err)
+	}
+	failed := consul.New("app", strings.TrimPrefix(srv.URL, "http://"))
+	if err := failed.Load(&testConfig{}); err == nil {
+		t.Error("expecting error")
+	}
+}
@@ -0,0 +1,21 @@
+package libkv
+
+import (
+	"github.com/go-mixins/loader/file"
+)
+
+// parseDocument parses the string value of the key if the tag options name
+// its document format, like "json" in `kv:"db,json"`
+func parseDocument(key string, val interface{}, tag string) (interface{}, error) {
+	s, ok := val.(string)
+	if !ok {
+		return val, nil
+	}
+	_, opts := parseTag(tag)
+	for _, opt := range opts {
+		if _, isDoc := file.LookupFormat("." + opt); isDoc {
+			return file.Parse(key+"."+opt, []byte(s))
+		}
+	}
+	return val, nil
+}
@@ -0,0 +1,98 @@
+package libkv_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/go-mixins/loader/libkv"
+)
+
+type docStruct struct {
+	DB struct {
+		Host string
+		Port int
+	} `kv:"db,json"`
+	Limits map[string]int `kv:",yaml"`
+	Hosts  []string       `kv:",json"`
+	Files  map[string]string
+	Start  time.Time
+}
+
+func TestLoad_Documents(t *testing.T) {
+	l, err := libkv.New("a", kvMock{
+		{Key: "a/db", Value: []byte(`{"host": "db", "port": 5432}`)},
+		{Key: "a/limits", Value: []byte("cpu: 2\nmem: 512\n")},
+		{Key: "a/hosts", Value: []byte(`["a", "b"]`)},
+		{Key: "a/files/example.json", Value: []byte(`{"a": 1}`)},
+		{Key: "a/start", Value: []byte("2020-01-02T03:04:05Z")},
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer l.Close()
+	var dest, expect docStruct
+	if err = l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect.DB.Host = "db"
+	expect.DB.Port = 5432
+	expect.Limits = map[string]int{"cpu": 2, "mem": 512}
+	expect.Hosts = []string{"a", "b"}
+	expect.Files = map[string]string{"example.json": `{"a": 1}`}
+	expect.Start = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if diff := deep.Equal(expect, dest); diff != nil {
+		t.Errorf("%+v", diff)
+	}
+	// The values of fields not declared as documents are not parsed
+	l, err = libkv.New("a", kvMock{{Key: "a/b", Value: []byte(`{"c": 1}`)}})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer l.Close()
+	if err = l.Load(&testStruct{}); err == nil {
+		t.Error("expecting error for undeclared document")
+	}
+}
+
+type taggedDocStruct struct {
+	DB struct {
+		Host string
+		Port int
+	} `kv:"database,yaml"`
+	Raw  interface{} `kv:",json"`
+	Text string
+}
+
+func TestLoad_DocumentTags(t *testing.T) {
+	l, err := libkv.New("a", kvMock{
+		{Key: "a/database", Value: []byte("host: db\nport: 5432\n")},
+		{Key: "a/raw", Value: []byte(`{"a": [1, 2]}`)},
+		{Key: "a/text", Value: []byte(`{"a": 1}`)},
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer l.Close()
+	var dest, expect taggedDocStruct
+	if err = l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect.DB.Host = "db"
+	expect.DB.Port = 5432
+	expect.Raw = map[string]interface{}{"a": []interface{}{1.0, 2.0}}
+	expect.Text = `{"a": 1}`
+	if diff := deep.Equal(expect, dest); diff != nil {
+		t.Errorf("%+v", diff)
+	}
+	l, err = libkv.New("a", kvMock{{Key: "a/raw", Value: []byte(`{`)}})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer l.Close()
+	if err = l.Load(&dest); err == nil || !strings.Contains(err.Error(), "raw.json") {
+		t.Errorf("parsing error expected, got %+v", err)
+	}
+}
@@ -0,0 +1,226 @@
+package libkv
+
+import (
+	"encoding"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libkv/store"
+
+	"github.com/go-mixins/loader"
+)
+
+type kvWriter interface {
+	// List the content of a given prefix
+	List(directory string) ([]*store.KVPair, error)
+	// Put a value at the specified key
+	Put(key string, value []byte, options *store.WriteOptions) error
+	// Atomic CAS operation on a single value
+	AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error)
+	// Delete the value at the specified key
+	Delete(key string) error
+}
+
+// SaveOptions control writing configuration into KV store
+type SaveOptions struct {
+	// CAS makes every write conditional on the key being unchanged since
+	// the prefix was listed at the start of Save. Save fails with
+	// store.ErrKeyModified or store.ErrKeyExists cause if another
+	// publisher has written in between.
+	CAS bool
+	// Prune deletes the keys under prefix that are not present in the
+	// saved object. The directories are left in place. When walking, the
+	// empty directories can't be told from the keys with empty values, so
+	// such keys are left in place too.
+	Prune bool
+	// Naming of the keys, Lowercase by default
+	Naming Naming
+	// Walk is the number of concurrent requests made to walk the prefix
+	// level by level, like in Loader.WithWalk. It is required for CAS and
+	// Prune with the stores which list only direct children of the
+	// directory, like etcd.
+	Walk int
+}
+
+// Save writes the object into KV store under prefix, in the layout Load
+// expects. The options may be nil. The keys are written one by one in sorted
+// order, so Save is not atomic across keys: if a write fails, including CAS
+// conflict, the keys written before it are left updated.
+func Save(kv kvWriter, prefix string, src interface{}, opts *SaveOptions) error {
+	if opts == nil {
+		opts = new(SaveOptions)
+	}
+	prefix = strings.Trim(prefix, "/")
+	values, err := opts.Naming.withDefault().encode(src)
+	if err != nil {
+		return err
+	}
+	var pairs []*store.KVPair
+	if opts.CAS || opts.Prune {
+		if pairs, err = listTree(kv, prefix, opts.Walk); err != nil {
+			return err
+		}
+	}
+	current := make(map[string]*store.KVPair, len(pairs))
+	dirs := make(map[string]bool)
+	for _, p := range pairs {
+		key := strings.Trim(p.Key, "/")
+		current[key] = p
+		for i := strings.LastIndex(key, "/"); i > 0; i = strings.LastIndex(key[:i], "/") {
+			dirs[key[:i]] = true
+		}
+	}
+	for _, k := range sortedKeys(values) {
+		key := joinKey(prefix, k)
+		value := []byte(values[k])
+		if !opts.CAS {
+			if err = kv.Put(key, value, nil); err != nil {
+				return loader.Errors.Wrapf(err, "putting %q", key)
+			}
+			continue
+		}
+		if _, _, err = kv.AtomicPut(key, value, current[key], nil); err != nil {
+			return loader.Errors.Wrapf(err, "putting %q", key)
+		}
+	}
+	if !opts.Prune {
+		return nil
+	}
+	for key := range current {
+		if dirs[key] || opts.Walk > 0 && len(current[key].Value) == 0 {
+			// the directories are left in place
+			continue
+		}
+		if _, ok := values[strings.TrimPrefix(key, prefix+"/")]; ok {
+			continue
+		}
+		if err = kv.Delete(key); err != nil && err != store.ErrKeyNotFound {
+			return loader.Errors.Wrapf(err, "deleting %q", key)
+		}
+	}
+	return nil
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "/" + key
+}
+
+func sortedKeys(m map[string]string) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// Encode converts the object into the map of slash-separated keys to values,
+// in the layout Load expects. Nested structs and maps become path segments,
+// slices and arrays are indexed from 0, encoding.TextMarshaler values are
+// marshaled and []byte values are base64-encoded. The fields are named with
+// Lowercase naming. Nil values are omitted.
+func Encode(src interface{}) (map[string]string, error) {
+	return Lowercase.encode(src)
+}
+
+func (n Naming) encode(src interface{}) (map[string]string, error) {
+	res := make(map[string]string)
+	if err := n.encodeValue(res, "", reflect.ValueOf(src)); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (n Naming) encodeValue(dest map[string]string, key string, v reflect.Value) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		if v.Type().Implements(textMarshalerType) {
+			break
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Type().Implements(textMarshalerType) || reflect.PtrTo(v.Type()).Implements(textMarshalerType) && v.CanAddr() {
+		m, ok := v.Interface().(encoding.TextMarshaler)
+		if !ok {
+			m = v.Addr().Interface().(encoding.TextMarshaler)
+		}
+		text, err := m.MarshalText()
+		if err != nil {
+			return loader.Errors.Wrapf(err, "marshaling %q", key)
+		}
+		return put1(dest, key, string(text))
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name, squash := n.Key(f)
+			if name == "-" {
+				continue
+			}
+			fieldKey := key
+			if !squash {
+				fieldKey = joinKey(key, name)
+			}
+			if err := n.encodeValue(dest, fieldKey, v.Field(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			if err := n.encodeValue(dest, joinKey(key, fmt.Sprint(k.Interface())), v.MapIndex(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return put1(dest, key, base64.StdEncoding.EncodeToString(v.Bytes()))
+		}
+		fallthrough
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := n.encodeValue(dest, joinKey(key, strconv.Itoa(i)), v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Float32, reflect.Float64:
+		return put1(dest, key, strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()))
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Type() == durationType {
+			return put1(dest, key, v.Interface().(fmt.Stringer).String())
+		}
+		return put1(dest, key, fmt.Sprint(v.Interface()))
+	}
+	return loader.Errors.Errorf("can't encode %q of type %s", key, v.Type())
+}
+
+func put1(dest map[string]string, key, value string) error {
+	if key == "" {
+		return loader.Errors.New("can't encode scalar without key")
+	}
+	dest[key] = value
+	return nil
+}
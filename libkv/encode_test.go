@@ -0,0 +1,228 @@
+package libkv_test
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/go-test/deep"
+
+	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/libkv"
+)
+
+type kvMap struct {
+	pairs map[string]*store.KVPair
+	index uint64
+}
+
+func (kvm *kvMap) Close() {}
+
+func (kvm *kvMap) Get(key string) (*store.KVPair, error) {
+	if p, ok := kvm.pairs[key]; ok {
+		return p, nil
+	}
+	return nil, store.ErrKeyNotFound
+}
+
+func (kvm *kvMap) List(directory string) (res []*store.KVPair, err error) {
+	for k, p := range kvm.pairs {
+		if strings.HasPrefix(k, directory+"/") {
+			res = append(res, p)
+		}
+	}
+	return
+}
+
+func (kvm *kvMap) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+func (kvm *kvMap) Put(key string, value []byte, options *store.WriteOptions) error {
+	kvm.index++
+	kvm.pairs[key] = &store.KVPair{Key: key, Value: value, LastIndex: kvm.index}
+	return nil
+}
+
+func (kvm *kvMap) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	p, ok := kvm.pairs[key]
+	switch {
+	case previous == nil && ok:
+		return false, nil, store.ErrKeyExists
+	case previous != nil && (!ok || p.LastIndex != previous.LastIndex):
+		return false, nil, store.ErrKeyModified
+	}
+	kvm.Put(key, value, options)
+	return true, kvm.pairs[key], nil
+}
+
+func (kvm *kvMap) Delete(key string) error {
+	delete(kvm.pairs, key)
+	return nil
+}
+
+type Common struct {
+	Name string
+}
+
+type saveStruct struct {
+	Common  `mapstructure:",squash"`
+	Timeout time.Duration
+	Ratio   float64 `mapstructure:"k"`
+	Addr    net.IP
+	Data    []byte
+	Hosts   []string
+	Labels  map[string]int
+	Ptr     *struct{ X int }
+	Skip    string `mapstructure:"-"`
+}
+
+func TestEncode(t *testing.T) {
+	var src saveStruct
+	src.Name = "app"
+	src.Timeout = 1500 * time.Millisecond
+	src.Ratio = 0.25
+	src.Addr = net.IPv4(10, 0, 0, 1)
+	src.Data = []byte("data")
+	src.Hosts = []string{"a", "b"}
+	src.Labels = map[string]int{"x": 1}
+	src.Skip = "skip"
+	res, err := libkv.Encode(&src)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := map[string]string{
+		"name":     "app",
+		"timeout":  "1.5s",
+		"k":        "0.25",
+		"addr":     "10.0.0.1",
+		"data":     "ZGF0YQ==",
+		"hosts/0":  "a",
+		"hosts/1":  "b",
+		"labels/x": "1",
+	}
+	if diff := deep.Equal(expect, res); diff != nil {
+		t.Errorf("%+v", diff)
+	}
+}
+
+func TestSave(t *testing.T) {
+	kv := &kvMap{pairs: make(map[string]*store.KVPair)}
+	kv.Put("a/stale", []byte("x"), nil)
+	var src, dest testStruct
+	src.B.C = 1
+	src.D = "string"
+	src.E = append(src.E, struct {
+		X float64
+		Y string
+	}{0.1, "y1"})
+	if err := libkv.Save(kv, "a", &src, &libkv.SaveOptions{CAS: true, Prune: true}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, ok := kv.pairs["a/stale"]; ok {
+		t.Error("stale key must be pruned")
+	}
+	l, err := libkv.New("a", kv)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer l.Close()
+	if err = l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if diff := deep.Equal(src, dest); diff != nil {
+		t.Errorf("%+v", diff)
+	}
+	// Another publisher writes in between
+	race := &casRace{kvMap: kv, race: func() { kv.Put("a/d", []byte("other"), nil) }}
+	src.D = "mine"
+	err = libkv.Save(race, "a", &src, &libkv.SaveOptions{CAS: true})
+	if err == nil || !loader.Errors.Contains(err) {
+		t.Errorf("expecting modification error, got %v", err)
+	}
+	if v := kv.pairs["a/d"].Value; !bytes.Equal(v, []byte("other")) {
+		t.Errorf("invalid value %q", v)
+	}
+}
+
+// casRace modifies the store after it has been listed
+type casRace struct {
+	*kvMap
+	race func()
+}
+
+func (c *casRace) List(directory string) ([]*store.KVPair, error) {
+	res, err := c.kvMap.List(directory)
+	c.race()
+	return res, err
+}
+
+// kvDirMap lists only direct children with leading slash, like etcd does.
+// The directories have empty values, the empty ones are listed in dirs.
+type kvDirMap struct {
+	*kvMap
+	dirs map[string]bool
+}
+
+func (kvm kvDirMap) List(directory string) (res []*store.KVPair, err error) {
+	seen := make(map[string]bool)
+	for dir := range kvm.dirs {
+		if strings.TrimPrefix(dir, directory+"/") != dir && !strings.Contains(strings.TrimPrefix(dir, directory+"/"), "/") {
+			res = append(res, &store.KVPair{Key: "/" + dir})
+		}
+	}
+	for k, p := range kvm.pairs {
+		rest := strings.TrimPrefix(k, directory+"/")
+		if rest == k {
+			continue
+		}
+		if i := strings.Index(rest, "/"); i >= 0 {
+			if dir := directory + "/" + rest[:i]; !seen[dir] {
+				seen[dir] = true
+				res = append(res, &store.KVPair{Key: "/" + dir})
+			}
+			continue
+		}
+		res = append(res, &store.KVPair{Key: "/" + k, Value: p.Value, LastIndex: p.LastIndex})
+	}
+	return
+}
+
+func (kvm kvDirMap) Delete(key string) error {
+	if kvm.dirs[key] {
+		return errors.New("not a file")
+	}
+	for k := range kvm.pairs {
+		if strings.HasPrefix(k, key+"/") {
+			return errors.New("not a file")
+		}
+	}
+	return kvm.kvMap.Delete(key)
+}
+
+func TestSave_Walk(t *testing.T) {
+	kv := &kvMap{pairs: make(map[string]*store.KVPair)}
+	kv.Put("a/b/c", []byte("0"), nil)
+	kv.Put("a/e/0/x", []byte("0.5"), nil)
+	kv.Put("a/stale/x", []byte("x"), nil)
+	var src testStruct
+	src.B.C = 1
+	src.D = "string"
+	err := libkv.Save(kvDirMap{kv, map[string]bool{"a/empty": true}}, "a", &src, &libkv.SaveOptions{CAS: true, Prune: true, Walk: 2})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, ok := kv.pairs["a/stale/x"]; ok {
+		t.Error("nested stale key must be pruned")
+	}
+	if _, ok := kv.pairs["a/e/0/x"]; ok {
+		t.Error("nested stale slice element must be pruned")
+	}
+	if v := kv.pairs["a/b/c"].Value; !bytes.Equal(v, []byte("1")) {
+		t.Errorf("invalid value %q", v)
+	}
+}
@@ -0,0 +1,48 @@
+package etcd
+
+import (
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libkv/store/etcd"
+
+	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/libkv"
+)
+
+// WalkConcurrency limits the number of concurrent requests made while
+// walking the prefix, since etcd lists only direct children of the directory
+var WalkConcurrency = 8
+
+// Loader implements etcd Loader
+type Loader struct {
+	*libkv.Loader
+	err error
+}
+
+// New creates etcd loader initialized with specific prefix and endpoints
+func New(prefix string, endpoints ...string) (res *Loader) {
+	res = new(Loader)
+	kv, err := etcd.New(
+		endpoints,
+		&store.Config{
+			ConnectionTimeout: 10 * time.Second,
+		},
+	)
+	if err != nil {
+		res.err = loader.Errors.Wrap(err, "creating etcd source")
+		return
+	}
+	if res.Loader, res.err = libkv.New(prefix, kv); res.err == nil {
+		res.Loader.WithWalk(WalkConcurrency)
+	}
+	return
+}
+
+// Load loads the target from etcd source
+func (l *Loader) Load(dest interface{}) error {
+	if l.err != nil {
+		return l.err
+	}
+	return l.Loader.Load(dest)
+}
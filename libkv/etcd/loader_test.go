@@ -0,0 +1,299 @@
+package etcd_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/libkv/store"
+	libkvetcd "github.com/docker/libkv/store/etcd"
+
+	"github.com/go-mixins/loader/libkv/etcd"
+	"github.com/go-mixins/loader/libkv/internal/kvtest"
+)
+
+type node struct {
+	Key           string  `json:"key"`
+	Value         string  `json:"value,omitempty"`
+	Dir           bool    `json:"dir,omitempty"`
+	Nodes         []*node `json:"nodes,omitempty"`
+	CreatedIndex  uint64  `json:"createdIndex,omitempty"`
+	ModifiedIndex uint64  `json:"modifiedIndex,omitempty"`
+}
+
+type event struct {
+	Action   string `json:"action"`
+	Node     *node  `json:"node"`
+	PrevNode *node  `json:"prevNode,omitempty"`
+}
+
+type etcdError struct {
+	status  int
+	Code    int    `json:"errorCode"`
+	Message string `json:"message"`
+	Cause   string `json:"cause"`
+	Index   uint64 `json:"index"`
+}
+
+// fakeEtcd serves the keys and members parts of etcd v2 API, including
+// recursive listing and waiting for changes
+type fakeEtcd struct {
+	sync.Mutex
+	url     string
+	index   uint64
+	nodes   map[string]*node
+	events  []event
+	changed chan struct{}
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{
+		index:   1,
+		nodes:   map[string]*node{"/": {Key: "/", Dir: true}},
+		changed: make(chan struct{}),
+	}
+}
+
+func (f *fakeEtcd) fail(code int, key string) *etcdError {
+	res := &etcdError{Code: code, Cause: key, Index: f.index}
+	switch code {
+	case 100:
+		res.status, res.Message = http.StatusNotFound, "Key not found"
+	case 101:
+		res.status, res.Message = http.StatusPreconditionFailed, "Compare failed"
+	case 102:
+		res.status, res.Message = http.StatusForbidden, "Not a file"
+	case 104:
+		res.status, res.Message = http.StatusForbidden, "Not a directory"
+	case 105:
+		res.status, res.Message = http.StatusPreconditionFailed, "Key already exists"
+	case 108:
+		res.status, res.Message = http.StatusForbidden, "Directory not empty"
+	}
+	return res
+}
+
+func parent(key string) string {
+	if i := strings.LastIndex(key, "/"); i > 0 {
+		return key[:i]
+	}
+	return "/"
+}
+
+func under(key, dir string) bool {
+	return key == dir || dir == "/" || strings.HasPrefix(key, dir+"/")
+}
+
+// tree returns the copy of the node with children, if listed
+func (f *fakeEtcd) tree(n *node, children, recursive bool) *node {
+	res := *n
+	res.Nodes = nil
+	if !n.Dir || !children {
+		return &res
+	}
+	for k, c := range f.nodes {
+		if k != "/" && parent(k) == n.Key {
+			res.Nodes = append(res.Nodes, f.tree(c, recursive, recursive))
+		}
+	}
+	sort.Slice(res.Nodes, func(i, j int) bool { return res.Nodes[i].Key < res.Nodes[j].Key })
+	return &res
+}
+
+func (f *fakeEtcd) notify(ev event) {
+	f.events = append(f.events, ev)
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeEtcd) set(key string, r *http.Request) (int, *event, *etcdError) {
+	prev, exists := f.nodes[key]
+	dir := r.FormValue("dir") == "true"
+	switch {
+	case r.FormValue("prevExist") == "false" && exists:
+		return 0, nil, f.fail(105, key)
+	case r.FormValue("prevExist") == "true" && !exists:
+		return 0, nil, f.fail(100, key)
+	case r.FormValue("prevIndex") != "" && !exists:
+		return 0, nil, f.fail(100, key)
+	case r.FormValue("prevIndex") != "" && r.FormValue("prevIndex") != strconv.FormatUint(prev.ModifiedIndex, 10):
+		return 0, nil, f.fail(101, key)
+	case exists && prev.Dir:
+		return 0, nil, f.fail(102, key)
+	}
+	for p := parent(key); p != "/"; p = parent(p) {
+		if n, ok := f.nodes[p]; ok && !n.Dir {
+			return 0, nil, f.fail(104, p)
+		}
+	}
+	f.index++
+	for p := parent(key); p != "/"; p = parent(p) {
+		if _, ok := f.nodes[p]; !ok {
+			f.nodes[p] = &node{Key: p, Dir: true, CreatedIndex: f.index, ModifiedIndex: f.index}
+		}
+	}
+	n := &node{Key: key, Dir: dir, CreatedIndex: f.index, ModifiedIndex: f.index}
+	if !dir {
+		n.Value = r.FormValue("value")
+	}
+	ev := event{Action: "set", Node: n}
+	status := http.StatusCreated
+	if exists {
+		n.CreatedIndex, ev.PrevNode, status = prev.CreatedIndex, prev, http.StatusOK
+		if r.FormValue("prevIndex") != "" {
+			ev.Action = "compareAndSwap"
+		}
+	} else if r.FormValue("prevExist") == "false" {
+		ev.Action = "create"
+	}
+	f.nodes[key] = n
+	f.notify(ev)
+	return status, &ev, nil
+}
+
+func (f *fakeEtcd) remove(key string, r *http.Request) (*event, *etcdError) {
+	n, ok := f.nodes[key]
+	if !ok || key == "/" {
+		return nil, f.fail(100, key)
+	}
+	recursive := r.FormValue("recursive") == "true"
+	if n.Dir && !recursive {
+		if r.FormValue("dir") != "true" {
+			return nil, f.fail(102, key)
+		}
+		for k := range f.nodes {
+			if k != key && under(k, key) {
+				return nil, f.fail(108, key)
+			}
+		}
+	}
+	if r.FormValue("prevIndex") != "" && r.FormValue("prevIndex") != strconv.FormatUint(n.ModifiedIndex, 10) {
+		return nil, f.fail(101, key)
+	}
+	f.index++
+	for k := range f.nodes {
+		if under(k, key) {
+			delete(f.nodes, k)
+		}
+	}
+	ev := event{Action: "delete", Node: &node{Key: key, Dir: n.Dir, ModifiedIndex: f.index}, PrevNode: n}
+	if r.FormValue("prevIndex") != "" {
+		ev.Action = "compareAndDelete"
+	}
+	f.notify(ev)
+	return &ev, nil
+}
+
+// wait blocks until the event under the key happens at or after the index,
+// zero index meaning the next event
+func (f *fakeEtcd) wait(r *http.Request, key string, index uint64) *event {
+	if index == 0 {
+		index = f.index + 1
+	}
+	recursive := r.FormValue("recursive") == "true"
+	for {
+		for i := range f.events {
+			ev := &f.events[i]
+			if ev.Node.ModifiedIndex >= index && (ev.Node.Key == key || (recursive && under(ev.Node.Key, key))) {
+				return ev
+			}
+		}
+		changed := f.changed
+		f.Unlock()
+		select {
+		case <-changed:
+			f.Lock()
+		case <-r.Context().Done():
+			f.Lock()
+			return nil
+		}
+	}
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path == "/v2/members" {
+		json.NewEncoder(w).Encode(map[string]interface{}{"members": []interface{}{map[string]interface{}{
+			"id":         "8e9e05c52164694d",
+			"name":       "fake",
+			"peerURLs":   []string{"http://localhost:2380"},
+			"clientURLs": []string{f.url},
+		}}})
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/v2/keys") {
+		http.NotFound(w, r)
+		return
+	}
+	key := "/" + strings.Trim(strings.TrimPrefix(r.URL.Path, "/v2/keys"), "/")
+	var (
+		res    interface{}
+		status = http.StatusOK
+		err    *etcdError
+	)
+	switch r.Method {
+	case http.MethodGet:
+		if r.FormValue("wait") == "true" {
+			index, _ := strconv.ParseUint(r.FormValue("waitIndex"), 10, 64)
+			ev := f.wait(r, key, index)
+			if ev == nil {
+				return
+			}
+			res = ev
+			break
+		}
+		n, ok := f.nodes[key]
+		if !ok {
+			err = f.fail(100, key)
+			break
+		}
+		res = event{Action: "get", Node: f.tree(n, true, r.FormValue("recursive") == "true")}
+	case http.MethodPut:
+		var ev *event
+		status, ev, err = f.set(key, r)
+		res = ev
+	case http.MethodDelete:
+		var ev *event
+		ev, err = f.remove(key, r)
+		res = ev
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("X-Etcd-Index", strconv.FormatUint(f.index, 10))
+	if err != nil {
+		status, res = err.status, err
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+// TestLoader runs against the fake etcd server, or against etcd cluster
+// listed in LIBKV_ETCD_ENDPOINTS environment variable
+func TestLoader(t *testing.T) {
+	endpoints := strings.Split(os.Getenv("LIBKV_ETCD_ENDPOINTS"), ",")
+	if endpoints[0] == "" {
+		fake := newFakeEtcd()
+		srv := httptest.NewServer(fake)
+		defer srv.Close()
+		fake.url = srv.URL
+		endpoints = []string{strings.TrimPrefix(srv.URL, "http://")}
+	}
+	kvtest.Run(t, kvtest.Backend{
+		Store: func() (store.Store, error) {
+			return libkvetcd.New(endpoints, &store.Config{})
+		},
+		Loader: func(prefix string) kvtest.Loader {
+			return etcd.New(prefix, endpoints...)
+		},
+		Watch: true,
+	})
+}
@@ -0,0 +1,96 @@
+// Package kvtest provides the integration test suite shared by libkv
+// backends
+package kvtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/go-test/deep"
+
+	"github.com/go-mixins/loader"
+)
+
+// Loader is the loader under test
+type Loader interface {
+	loader.Loader
+	Close() error
+}
+
+// Backend describes the backend under test
+type Backend struct {
+	// Store connects to the backend to seed the data
+	Store func() (store.Store, error)
+	// Loader creates the loader for the prefix connected to the same
+	// backend
+	Loader func(prefix string) Loader
+	// Watch is set if the backend reports changes
+	Watch bool
+}
+
+type backend struct {
+	Host   string
+	Weight float64
+}
+
+type config struct {
+	Name string
+	DB   struct {
+		Host string
+		Port int
+	}
+	Backends []backend
+}
+
+const prefix = "kvtest/app"
+
+// Run executes the suite against the backend
+func Run(t *testing.T, b Backend) {
+	kv, err := b.Store()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer kv.Close()
+	defer kv.DeleteTree("kvtest")
+	for k, v := range map[string]string{
+		prefix + "/name":              "app",
+		prefix + "/db/host":           "localhost",
+		prefix + "/db/port":           "5432",
+		prefix + "/backends/0/host":   "b0",
+		prefix + "/backends/0/weight": "0.5",
+		prefix + "/backends/1/host":   "b1",
+		prefix + "/backends/1/weight": "1",
+		"kvtest/other/name":           "other",
+	} {
+		if err := kv.Put(k, []byte(v), nil); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	expect := config{
+		Name:     "app",
+		Backends: []backend{{"b0", 0.5}, {"b1", 1}},
+	}
+	expect.DB.Host = "localhost"
+	expect.DB.Port = 5432
+	var dest config
+	l := b.Loader(prefix)
+	defer l.Close()
+	if err = l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if diff := deep.Equal(expect, dest); diff != nil {
+		t.Errorf("%+v", diff)
+	}
+	if !b.Watch {
+		return
+	}
+	if err = kv.Put(prefix+"/name", []byte("changed"), nil); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	select {
+	case <-l.Changes():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for change")
+	}
+}
@@ -2,6 +2,8 @@ package libkv
 
 import (
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/docker/libkv/store"
 
@@ -12,9 +14,23 @@ import (
 type Loader struct {
 	store         kvStore
 	prefix        string
+	walk          int
+	naming        Naming
 	changes, stop chan struct{}
+	lock          sync.Mutex
+	onError       func(error)
+	closeOnce     sync.Once
+	// backoff bounds are taken when the loader is created
+	minBackoff, maxBackoff time.Duration
 }
 
+// MinBackoff and MaxBackoff bound the delay between attempts to re-establish
+// lost watch
+var (
+	MinBackoff = 100 * time.Millisecond
+	MaxBackoff = 30 * time.Second
+)
+
 var _ loader.Loader = (*Loader)(nil)
 
 type kvStore interface {
@@ -29,42 +45,150 @@ type kvStore interface {
 	Close()
 }
 
-// Close closes underlying changes channel
+// Close closes underlying changes channel and the store. It may be called
+// more than once.
 func (l *Loader) Close() error {
-	close(l.stop)
-	l.store.Close()
+	l.closeOnce.Do(func() {
+		close(l.stop)
+		l.store.Close()
+	})
 	return nil
 }
 
-// Changes provides source of config change events. For environment variables
-// there will never be any.
+// Changes provides source of config change events. The change is also
+// reported after lost watch is re-established.
 func (l *Loader) Changes() <-chan struct{} {
 	return l.changes
 }
 
+// WithWatchError sets the handler of watch errors and returns the Loader.
+// The handler is called from the watching goroutine when the watch is lost or
+// can't be re-established.
+func (l *Loader) WithWatchError(handler func(error)) *Loader {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.onError = handler
+	return l
+}
+
+// WithWalk makes the loader walk the prefix level by level with the specified
+// number of concurrent requests, and returns the Loader. This is required for
+// the stores which list only direct children of the directory, like etcd or
+// Zookeeper. By default the whole prefix is fetched with single List request.
+func (l *Loader) WithWalk(concurrency int) *Loader {
+	l.walk = concurrency
+	return l
+}
+
+// WithNaming sets the naming of keys and returns the Loader
+func (l *Loader) WithNaming(naming Naming) *Loader {
+	l.naming = naming.withDefault()
+	return l
+}
+
 // New creates loader initialized with KV store prefix
-func New(prefix string, store kvStore) (res *Loader, err error) {
+func New(prefix string, kv kvStore) (res *Loader, err error) {
 	res = &Loader{
-		store:   store,
-		prefix:  strings.Trim(prefix, "/"),
-		changes: make(chan struct{}),
-		stop:    make(chan struct{}),
+		store:      kv,
+		prefix:     strings.Trim(prefix, "/"),
+		naming:     Lowercase,
+		changes:    make(chan struct{}),
+		stop:       make(chan struct{}),
+		minBackoff: MinBackoff,
+		maxBackoff: MaxBackoff,
+	}
+	c, err := res.watchTree()
+	if err == store.ErrCallNotSupported {
+		// The changes are never reported for such stores
+		go res.idle()
+		return res, nil
 	}
-	c, err := res.store.WatchTree(prefix, res.stop)
 	if err != nil {
 		err = loader.Errors.Wrap(err, "watching for prefix")
 		return
 	}
-	go func() {
-		defer close(res.changes)
-		for {
+	go res.watch(c)
+	return
+}
+
+// watch reports the changes until the loader is closed. If the watch channel
+// gets closed, the watch is re-established with exponential backoff.
+func (l *Loader) watch(c <-chan []*store.KVPair) {
+	defer close(l.changes)
+	backoff := l.minBackoff
+	for {
+		if c == nil {
 			select {
-			case <-res.stop:
+			case <-l.stop:
+				return
+			case <-time.After(backoff):
+			}
+			var err error
+			c, err = l.watchTree()
+			if err == store.ErrCallNotSupported {
+				l.watchError(loader.Errors.New("watch is not supported anymore"))
+				<-l.stop
+				return
+			}
+			if err != nil {
+				l.watchError(loader.Errors.Wrap(err, "re-establishing watch"))
+				if backoff *= 2; backoff > l.maxBackoff {
+					backoff = l.maxBackoff
+				}
+				continue
+			}
+			backoff = l.minBackoff
+			// The changes might have been missed while reconnecting
+			if !l.notify() {
 				return
-			case <-c:
-				res.changes <- struct{}{}
 			}
 		}
-	}()
-	return
+		select {
+		case <-l.stop:
+			return
+		case _, ok := <-c:
+			if !ok {
+				l.watchError(loader.Errors.New("watch channel closed"))
+				c = nil
+				continue
+			}
+			if !l.notify() {
+				return
+			}
+		}
+	}
+}
+
+// watchTree establishes the watch. The store returning no channel and no
+// error is treated as not supporting the watch.
+func (l *Loader) watchTree() (<-chan []*store.KVPair, error) {
+	c, err := l.store.WatchTree(l.prefix, l.stop)
+	if err == nil && c == nil {
+		err = store.ErrCallNotSupported
+	}
+	return c, err
+}
+
+// idle waits for the loader to be closed, never reporting changes
+func (l *Loader) idle() {
+	defer close(l.changes)
+	<-l.stop
+}
+
+func (l *Loader) notify() bool {
+	select {
+	case <-l.stop:
+		return false
+	case l.changes <- struct{}{}:
+		return true
+	}
+}
+
+func (l *Loader) watchError(err error) {
+	l.lock.Lock()
+	handler := l.onError
+	l.lock.Unlock()
+	if handler != nil {
+		handler(err)
+	}
 }
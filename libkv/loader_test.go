@@ -2,12 +2,19 @@ package libkv_test
 
 import (
 	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/docker/libkv/store"
 	"github.com/go-test/deep"
 
+	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/crypt"
 	"github.com/go-mixins/loader/libkv"
 )
 
@@ -90,3 +97,358 @@ func TestLoad(t *testing.T) {
 		t.Errorf("%+v", diff)
 	}
 }
+
+func TestLoad_Encrypted(t *testing.T) {
+	key, _ := crypt.NewKey()
+	secret, _ := key.Encrypt("secret")
+	if old, ok := os.LookupEnv(crypt.KeyEnv); ok {
+		defer os.Setenv(crypt.KeyEnv, old)
+	} else {
+		defer os.Unsetenv(crypt.KeyEnv)
+	}
+	os.Setenv(crypt.KeyEnv, key.String())
+	loader, err := libkv.New("a", kvMock{
+		{Key: "a/b/c", Value: []byte("1")},
+		{Key: "a/d", Value: []byte(secret)},
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer loader.Close()
+	var dest testStruct
+	if err = loader.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dest.D != "secret" {
+		t.Errorf("invalid result %+v", dest)
+	}
+}
+
+func TestLoad_RootPrefix(t *testing.T) {
+	for _, prefix := range []string{"", "/"} {
+		l, err := libkv.New(prefix, kvMock{
+			{Key: "b/c", Value: []byte("1")},
+			{Key: "/d", Value: []byte("x")},
+		})
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		var dest testStruct
+		if err = l.Load(&dest); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		l.Close()
+		if dest.B.C != 1 || dest.D != "x" {
+			t.Errorf("%q: invalid result %+v", prefix, dest)
+		}
+	}
+}
+
+type kvStore interface {
+	Get(key string) (*store.KVPair, error)
+	List(directory string) ([]*store.KVPair, error)
+	WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error)
+	Close()
+}
+
+// kvCounter counts the requests made to the store
+type kvCounter struct {
+	kvMock
+	requests int64
+}
+
+func (c *kvCounter) Get(key string) (*store.KVPair, error) {
+	atomic.AddInt64(&c.requests, 1)
+	return c.kvMock.Get(key)
+}
+
+func (c *kvCounter) List(directory string) ([]*store.KVPair, error) {
+	atomic.AddInt64(&c.requests, 1)
+	return c.kvMock.List(directory)
+}
+
+func (c *kvCounter) count() int64 { return atomic.LoadInt64(&c.requests) }
+
+// kvDirMock lists only direct children, like etcd does. The directories have
+// empty values.
+type kvDirMock struct {
+	kvCounter
+}
+
+func (kvm *kvDirMock) List(directory string) (res []*store.KVPair, err error) {
+	atomic.AddInt64(&kvm.requests, 1)
+	seen := make(map[string]bool)
+	for _, kv := range kvm.kvMock {
+		rest := strings.TrimPrefix(kv.Key, directory+"/")
+		if rest == kv.Key {
+			continue
+		}
+		if i := strings.Index(rest, "/"); i >= 0 {
+			dir := directory + "/" + rest[:i]
+			if !seen[dir] {
+				seen[dir] = true
+				res = append(res, &store.KVPair{Key: "/" + dir})
+			}
+			continue
+		}
+		res = append(res, &store.KVPair{Key: "/" + kv.Key, Value: kv.Value})
+	}
+	return
+}
+
+func genKeys(n int) kvMock {
+	res := make(kvMock, 0, n*2)
+	for i := 0; i < n; i++ {
+		res = append(res,
+			&store.KVPair{Key: fmt.Sprintf("a/e/%d/x", i), Value: []byte(strconv.Itoa(i))},
+			&store.KVPair{Key: fmt.Sprintf("a/e/%d/y", i), Value: []byte("y")},
+		)
+	}
+	return res
+}
+
+func TestLoad_Requests(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		kv       countingStore
+		walk     int
+		requests int64
+	}{
+		{"List", &kvCounter{kvMock: genKeys(10)}, 0, 1},
+		{"Walk", &kvDirMock{kvCounter{kvMock: genKeys(10)}}, 4, 12},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l, err := libkv.New("a", tc.kv)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			defer l.Close()
+			var dest testStruct
+			if err = l.WithWalk(tc.walk).Load(&dest); err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if len(dest.E) != 10 || dest.E[9].X != 9 || dest.E[9].Y != "y" {
+				t.Errorf("invalid result %+v", dest)
+			}
+			if n := tc.kv.count(); n != tc.requests {
+				t.Errorf("expected %d requests, got %d", tc.requests, n)
+			}
+		})
+	}
+}
+
+type countingStore interface {
+	kvStore
+	count() int64
+}
+
+func benchmarkLoad(b *testing.B, kv countingStore, walk int) {
+	l, err := libkv.New("a", kv)
+	if err != nil {
+		b.Fatalf("%+v", err)
+	}
+	defer l.Close()
+	l.WithWalk(walk)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var dest testStruct
+		if err = l.Load(&dest); err != nil {
+			b.Fatalf("%+v", err)
+		}
+	}
+	b.ReportMetric(float64(kv.count())/float64(b.N), "requests/op")
+}
+
+func BenchmarkLoad(b *testing.B) {
+	for _, n := range []int{10, 100, 500} {
+		b.Run(fmt.Sprintf("List/%d", n), func(b *testing.B) {
+			benchmarkLoad(b, &kvCounter{kvMock: genKeys(n)}, 0)
+		})
+		b.Run(fmt.Sprintf("Walk/%d", n), func(b *testing.B) {
+			benchmarkLoad(b, &kvDirMock{kvCounter{kvMock: genKeys(n)}}, 8)
+		})
+	}
+}
+
+type Base struct {
+	MaxConns int
+}
+
+type namingStruct struct {
+	Base    `kv:",squash"`
+	Name    string `kv:"service_name"`
+	Port    int    `json:"listen_port"`
+	Timeout int
+	Skip    string `kv:"-"`
+}
+
+func TestLoader_WithNaming(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		naming libkv.Naming
+		keys   kvMock
+		expect namingStruct
+	}{
+		{"Lowercase", libkv.Lowercase, kvMock{
+			{Key: "a/MaxConns", Value: []byte("1")},
+			{Key: "a/Service_Name", Value: []byte("x")},
+			{Key: "a/listen_port", Value: []byte("80")},
+			{Key: "a/timeout", Value: []byte("5")},
+			{Key: "a/skip", Value: []byte("y")},
+		}, namingStruct{Base: Base{1}, Name: "x", Port: 80, Timeout: 5}},
+		{"SnakeCase", libkv.SnakeCase, kvMock{
+			{Key: "a/max_conns", Value: []byte("1")},
+			{Key: "a/maxconns", Value: []byte("2")},
+			{Key: "a/timeout", Value: []byte("5")},
+		}, namingStruct{Base: Base{1}, Timeout: 5}},
+		{"KebabCase", libkv.KebabCase, kvMock{
+			{Key: "a/max-conns", Value: []byte("1")},
+		}, namingStruct{Base: Base{1}}},
+		{"Exact", libkv.Exact, kvMock{
+			{Key: "a/MaxConns", Value: []byte("1")},
+			{Key: "a/timeout", Value: []byte("5")},
+			{Key: "a/Timeout", Value: []byte("6")},
+		}, namingStruct{Base: Base{1}, Timeout: 6}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l, err := libkv.New("a", tc.keys)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			defer l.Close()
+			var dest namingStruct
+			if err = l.WithNaming(tc.naming).Load(&dest); err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if diff := deep.Equal(tc.expect, dest); diff != nil {
+				t.Errorf("%+v", diff)
+			}
+		})
+	}
+}
+
+// kvWatchMock returns the watch results one by one
+type kvWatchMock struct {
+	kvMock
+	watches chan func() (<-chan []*store.KVPair, error)
+	stop    chan struct{}
+}
+
+func newKVWatchMock(size int) kvWatchMock {
+	return kvWatchMock{
+		watches: make(chan func() (<-chan []*store.KVPair, error), size),
+		stop:    make(chan struct{}),
+	}
+}
+
+func (kvm kvWatchMock) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	select {
+	case watch := <-kvm.watches:
+		return watch()
+	case <-stopCh:
+	case <-kvm.stop:
+	}
+	return nil, store.ErrBackendNotSupported
+}
+
+// watch makes the next WatchTree call return c and err
+func (kvm kvWatchMock) watch(c chan []*store.KVPair, err error) {
+	select {
+	case kvm.watches <- func() (<-chan []*store.KVPair, error) { return c, err }:
+	case <-kvm.stop:
+	}
+}
+
+// send sends the value to the watch channel
+func (kvm kvWatchMock) send(c chan []*store.KVPair) {
+	select {
+	case c <- nil:
+	case <-kvm.stop:
+	}
+}
+
+func TestLoader_Watch(t *testing.T) {
+	defer func(min, max time.Duration) {
+		libkv.MinBackoff, libkv.MaxBackoff = min, max
+	}(libkv.MinBackoff, libkv.MaxBackoff)
+	libkv.MinBackoff, libkv.MaxBackoff = time.Millisecond, 4*time.Millisecond
+	kv := newKVWatchMock(0)
+	defer close(kv.stop)
+	first := make(chan []*store.KVPair)
+	go kv.watch(first, nil)
+	l, err := libkv.New("a", kv)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer l.Close()
+	errs := make(chan error, 10)
+	l.WithWatchError(func(err error) { errs <- err })
+	expectChange := func() {
+		select {
+		case <-l.Changes():
+		case <-time.After(time.Second):
+			t.Fatal("change expected")
+		}
+	}
+	go kv.send(first)
+	expectChange()
+	close(first)
+	kv.watch(nil, store.ErrBackendNotSupported)
+	second := make(chan []*store.KVPair)
+	kv.watch(second, nil)
+	expectChange()
+	go kv.send(second)
+	expectChange()
+	for _, expect := range []string{"watch channel closed", "re-establishing watch"} {
+		select {
+		case err := <-errs:
+			if !loader.Errors.Contains(err) || !strings.Contains(err.Error(), expect) {
+				t.Errorf("invalid error: %+v", err)
+			}
+		default:
+			t.Errorf("expected %q error", expect)
+		}
+	}
+}
+
+func TestNew_WatchFails(t *testing.T) {
+	kv := newKVWatchMock(1)
+	defer close(kv.stop)
+	kv.watch(nil, store.ErrBackendNotSupported)
+	if _, err := libkv.New("a", kv); !loader.Errors.Contains(err) || !strings.Contains(err.Error(), "watching for prefix") {
+		t.Errorf("invalid error: %+v", err)
+	}
+}
+
+func TestLoader_WatchNoChannel(t *testing.T) {
+	kv := newKVWatchMock(1)
+	defer close(kv.stop)
+	kv.watch(nil, nil)
+	l, err := libkv.New("a", kv)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	errs := make(chan error, 10)
+	l.WithWatchError(func(err error) { errs <- err })
+	kv.watch(make(chan []*store.KVPair), nil)
+	select {
+	case <-l.Changes():
+		t.Fatal("no changes expected")
+	case <-time.After(10 * libkv.MinBackoff):
+	}
+	if len(kv.watches) != 1 {
+		t.Error("watch must not be retried")
+	}
+	if len(errs) != 0 {
+		t.Errorf("unexpected error: %+v", <-errs)
+	}
+	l.Close()
+	select {
+	case _, ok := <-l.Changes():
+		if ok {
+			t.Error("no changes expected")
+		}
+	case <-time.After(time.Second):
+		t.Error("changes must be closed")
+	}
+}
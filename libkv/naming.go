@@ -0,0 +1,205 @@
+package libkv
+
+import (
+	"encoding"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// Naming defines how the keys are derived from struct fields. The key is
+// taken from the first present of `kv`, `mapstructure` or `json` tags. If the
+// tag has no name, like `kv:",squash"`, the key is derived from the field
+// name. The "squash" option puts the fields of embedded struct on the level
+// of the enclosing struct, "-" skips the field. The option of `kv` tag naming
+// registered file format, like `kv:"db,json"`, declares the value of the key
+// to be the document in that format.
+type Naming struct {
+	// Name returns the key for the field name
+	Name func(name string) string
+	// CaseSensitive disables case-insensitive matching of keys
+	CaseSensitive bool
+}
+
+var (
+	// Lowercase naming is the default one, where "maxconns" or "MaxConns"
+	// stands for MaxConns
+	Lowercase = Naming{Name: strings.ToLower}
+	// SnakeCase naming splits field names into words, so that "max_conns"
+	// stands for MaxConns
+	SnakeCase = Naming{Name: func(name string) string { return splitWords(name, '_') }}
+	// KebabCase naming is like SnakeCase, but with "max-conns" key
+	KebabCase = Naming{Name: func(name string) string { return splitWords(name, '-') }}
+	// Exact naming requires the keys to match the field names exactly
+	Exact = Naming{Name: func(name string) string { return name }, CaseSensitive: true}
+)
+
+// Key returns the key of the field, which is "-" for skipped fields, and
+// whether the field is squashed
+func (n Naming) Key(f reflect.StructField) (name string, squash bool) {
+	for _, tag := range []string{"kv", "mapstructure", "json"} {
+		if val, ok := f.Tag.Lookup(tag); ok {
+			name, opts := parseTag(val)
+			if name == "" {
+				name = n.Name(f.Name)
+			}
+			return name, hasOpt(opts, "squash")
+		}
+	}
+	return n.Name(f.Name), false
+}
+
+func (n Naming) withDefault() Naming {
+	if n.Name == nil {
+		return Lowercase
+	}
+	return n
+}
+
+func parseTag(tag string) (name string, opts []string) {
+	parts := strings.Split(tag, ",")
+	return parts[0], parts[1:]
+}
+
+func hasOpt(opts []string, opt string) bool {
+	for _, o := range opts {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// splitWords lower-cases the identifier and splits it into words, keeping
+// acronyms together, so that "DBMaxConns" becomes "db_max_conns"
+func splitWords(s string, sep rune) string {
+	runes := []rune(s)
+	var res []rune
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				res = append(res, sep)
+			}
+		}
+		res = append(res, unicode.ToLower(r))
+	}
+	return string(res)
+}
+
+func (n Naming) lookup(m map[string]interface{}, key string) (interface{}, bool) {
+	if val, ok := m[key]; ok {
+		return val, true
+	}
+	if n.CaseSensitive {
+		return nil, false
+	}
+	for k, val := range m {
+		if strings.EqualFold(k, key) {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// rename rewrites the keys of the tree into the ones expected by
+// mapstructure for the type
+func (n Naming) rename(tree interface{}, t reflect.Type) (interface{}, error) {
+	if t == nil {
+		return tree, nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch v := tree.(type) {
+	case map[string]interface{}:
+		switch t.Kind() {
+		case reflect.Struct:
+			if t.Implements(textUnmarshalerType) || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+				break
+			}
+			res := make(map[string]interface{})
+			return res, n.renameFields(res, v, t)
+		case reflect.Map, reflect.Slice, reflect.Array:
+			res := make(map[string]interface{}, len(v))
+			for k, val := range v {
+				var err error
+				if res[k], err = n.rename(val, t.Elem()); err != nil {
+					return nil, err
+				}
+			}
+			return res, nil
+		}
+	case []interface{}:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			break
+		}
+		res := make([]interface{}, len(v))
+		for i, val := range v {
+			var err error
+			if res[i], err = n.rename(val, t.Elem()); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	}
+	return tree, nil
+}
+
+// renameFields puts the values of struct fields from the tree into dest
+func (n Naming) renameFields(dest, tree map[string]interface{}, t reflect.Type) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		key, squash := n.Key(f)
+		msName, msOpts := parseTag(f.Tag.Get("mapstructure"))
+		if key == "-" || msName == "-" {
+			continue
+		}
+		if msName == "" {
+			msName = f.Name
+		}
+		var (
+			val   interface{}
+			found bool
+		)
+		if ft := indirect(f.Type); squash && ft.Kind() == reflect.Struct {
+			sub := make(map[string]interface{})
+			if err := n.renameFields(sub, tree, ft); err != nil {
+				return err
+			}
+			val, found = sub, len(sub) > 0
+		} else if val, found = n.lookup(tree, key); found {
+			var err error
+			if val, err = parseDocument(key, val, f.Tag.Get("kv")); err != nil {
+				return err
+			}
+			if val, err = n.rename(val, f.Type); err != nil {
+				return err
+			}
+		}
+		if !found {
+			continue
+		}
+		if sub, ok := val.(map[string]interface{}); ok && hasOpt(msOpts, "squash") {
+			for k, v := range sub {
+				dest[k] = v
+			}
+			continue
+		}
+		dest[msName] = val
+	}
+	return nil
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
@@ -7,14 +7,36 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/docker/libkv/store"
 	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/crypt"
 	"github.com/mitchellh/mapstructure"
 )
 
 // Load loads the target from libkv source
 func (l *Loader) Load(dest interface{}) error {
+	data, err := l.getTree(l.prefix)
+	if err != nil {
+		return err
+	}
+	return l.naming.Decode(data, dest)
+}
+
+// Decode puts the tree of map[string]interface{} values into the target
+// following the rules of libkv loader with Lowercase naming. This allows
+// other loaders of key-value data to share them.
+func Decode(tree interface{}, dest interface{}) error {
+	return Lowercase.Decode(tree, dest)
+}
+
+// Decode puts the tree of map[string]interface{} values into the target
+// following the rules of libkv loader. The encrypted values are decrypted,
+// and the values of fields declared as documents, like `kv:"db,json"`, are
+// parsed.
+func (n Naming) Decode(tree interface{}, dest interface{}) error {
 	cfg := &mapstructure.DecoderConfig{
 		Result:           dest,
 		DecodeHook:       decodeHook,
@@ -22,30 +44,47 @@ func (l *Loader) Load(dest interface{}) error {
 	}
 	decoder, err := mapstructure.NewDecoder(cfg)
 	if err != nil {
-		return loader.Errors.Wrap(err, "creating map decoder")
+		return loader.DecodeErrors.Wrap(err, "creating map decoder")
 	}
-	data, err := l.getRecursive(l.prefix)
-	if err != nil {
+	if tree, err = crypt.DecryptTree(tree); err != nil {
 		return err
 	}
-	return loader.Errors.Wrap(decoder.Decode(data), "decoding values")
+	if tree, err = n.withDefault().rename(tree, reflect.TypeOf(dest)); err != nil {
+		return err
+	}
+	return loader.DecodeErrors.Wrap(decoder.Decode(tree), "decoding values")
 }
 
+var durationType = reflect.TypeOf(time.Duration(0))
+
 func decodeHook(fromType reflect.Type, toType reflect.Type, data interface{}) (interface{}, error) {
 	// decode hook is borrowed from the excellent package
 	// "github.com/containous/staert"
 	// Copyright (c) 2016 Containous SAS, Emile Vauge, emile@vauge.com
 
 	// custom unmarshaler
-	textUnmarshalerType := reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
-	if toType.Implements(textUnmarshalerType) {
+	if s, ok := data.(string); ok && toType.Implements(textUnmarshalerType) {
 		object := reflect.New(toType.Elem()).Interface()
-		err := object.(encoding.TextUnmarshaler).UnmarshalText([]byte(data.(string)))
+		err := object.(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
 		if err != nil {
 			return nil, loader.Errors.Wrapf(err, "unmarshaling %v: %v", data, err)
 		}
 		return object, nil
 	}
+	if s, ok := data.(string); ok && reflect.PtrTo(toType).Implements(textUnmarshalerType) {
+		object := reflect.New(toType)
+		err := object.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		if err != nil {
+			return nil, loader.Errors.Wrapf(err, "unmarshaling %v", data)
+		}
+		return object.Elem().Interface(), nil
+	}
+	if s, ok := data.(string); ok && toType == durationType {
+		if d, err := time.ParseDuration(s); err == nil {
+			return d, nil
+		}
+		// plain numbers are decoded as nanoseconds
+	}
 	switch toType.Kind() {
 	case reflect.Ptr:
 		if fromType.Kind() == reflect.String {
@@ -98,6 +137,10 @@ func put(dest map[string]interface{}, path []string, val interface{}) {
 	case 0:
 		break
 	case 1:
+		if _, ok := dest[path[0]].(map[string]interface{}); ok {
+			// the value of directory key itself is ignored
+			break
+		}
 		dest[path[0]] = val
 	default:
 		tmp, ok := dest[path[0]].(map[string]interface{})
@@ -109,16 +152,19 @@ func put(dest map[string]interface{}, path []string, val interface{}) {
 	}
 }
 
-func (l *Loader) getRecursive(prefix string) (interface{}, error) {
-	pairs, err := l.store.List(prefix)
-	if err == store.ErrKeyNotFound {
-		return nil, nil
-	}
+// getTree fetches the values under prefix and reconstructs the tree. The
+// stores listing the keys recursively are queried with single List request,
+// the others are walked with bounded concurrency.
+func (l *Loader) getTree(prefix string) (interface{}, error) {
+	pairs, err := listTree(l.store, prefix, l.walk)
 	if err != nil {
-		return nil, loader.Errors.Wrapf(err, "getting KV list for %q", prefix)
+		return nil, err
 	}
 	if len(pairs) == 0 {
 		val, err := l.store.Get(prefix)
+		if err == store.ErrKeyNotFound {
+			return nil, nil
+		}
 		if err != nil {
 			return nil, loader.Errors.Wrapf(err, "getting key value for %q", prefix)
 		}
@@ -127,13 +173,94 @@ func (l *Loader) getRecursive(prefix string) (interface{}, error) {
 		}
 		return nil, nil
 	}
+	// Sorting puts parent keys before their children
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
 	res := make(map[string]interface{})
 	for _, p := range pairs {
-		val, err := l.getRecursive(p.Key)
+		key := strings.Trim(strings.TrimPrefix(p.Key, prefix), "/")
+		if key == "" {
+			continue
+		}
+		put(res, strings.Split(key, "/"), string(p.Value))
+	}
+	return res, nil
+}
+
+type kvLister interface {
+	// List the content of a given prefix
+	List(directory string) ([]*store.KVPair, error)
+}
+
+// listTree returns all the pairs under prefix, with single List request or,
+// if walk is positive, walking the prefix with that many concurrent requests.
+// The keys have no leading slash. When walking, the directories are returned
+// as pairs with empty values.
+func listTree(kv kvLister, prefix string, walk int) ([]*store.KVPair, error) {
+	if walk > 0 {
+		return walkTree(kv, prefix, walk)
+	}
+	return list(kv, prefix)
+}
+
+// list returns the pairs under prefix. Some stores list the keys by string
+// prefix, including the key itself and its siblings having the same prefix,
+// so these are filtered out. Empty prefix stands for the root, listing all
+// the keys.
+func list(kv kvLister, prefix string) ([]*store.KVPair, error) {
+	pairs, err := kv.List(prefix)
+	if err != nil && err != store.ErrKeyNotFound {
+		return nil, loader.Errors.Wrapf(err, "getting KV list for %q", prefix)
+	}
+	res := make([]*store.KVPair, 0, len(pairs))
+	for _, p := range pairs {
+		key := strings.TrimLeft(p.Key, "/")
+		if key == "" || prefix != "" && !strings.HasPrefix(key, prefix+"/") {
+			continue
+		}
+		p := *p
+		p.Key = key
+		res = append(res, &p)
+	}
+	return res, nil
+}
+
+// walkTree lists the prefix level by level, for the stores returning only
+// direct children
+func walkTree(kv kvLister, prefix string, concurrency int) ([]*store.KVPair, error) {
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		res  []*store.KVPair
+		errs []error
+		sem  = make(chan struct{}, concurrency)
+	)
+	var walk func(key string)
+	walk = func(key string) {
+		defer wg.Done()
+		sem <- struct{}{}
+		pairs, err := list(kv, key)
+		<-sem
+		mu.Lock()
+		defer mu.Unlock()
 		if err != nil {
-			return res, err
+			errs = append(errs, err)
+			return
 		}
-		put(res, strings.Split(strings.TrimPrefix(p.Key, prefix+"/"), "/"), val)
+		res = append(res, pairs...)
+		for _, p := range pairs {
+			if len(p.Value) > 0 {
+				// only the directories are listed further
+				continue
+			}
+			wg.Add(1)
+			go walk(strings.TrimRight(p.Key, "/"))
+		}
+	}
+	wg.Add(1)
+	walk(prefix)
+	wg.Wait()
+	if len(errs) > 0 {
+		return nil, errs[0]
 	}
 	return res, nil
 }
@@ -5,6 +5,10 @@ import "github.com/go-mixins/errors"
 // Errors defines error class that all returned errors belong to
 var Errors = errors.NewClass("config.loader")
 
+// DecodeErrors are the errors of parsing the loaded data and decoding it
+// into the target, which are not fixed by loading again
+var DecodeErrors = Errors.Sub("decode")
+
 // Loader updates fields in the target object
 type Loader interface {
 	// Load the specified object's fields
@@ -0,0 +1,177 @@
+// Package loadertest provides helpers for testing the code using loaders
+package loadertest
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/file"
+	"github.com/go-mixins/loader/libkv"
+)
+
+// Fake is a programmable loader holding configuration tree in memory. The
+// tree is decoded following the rules of libkv loader, so that the string
+// values are converted to the field types. All methods are safe for
+// concurrent use.
+type Fake struct {
+	lock    sync.Mutex
+	tree    map[string]interface{}
+	errs    []error
+	loads   []interface{}
+	changes chan struct{}
+	closed  bool
+}
+
+var _ loader.Loader = (*Fake)(nil)
+
+// NewFake creates Fake with the tree, which is either map[string]interface{}
+// or YAML document string. It panics if the tree is invalid.
+func NewFake(tree interface{}) *Fake {
+	res := &Fake{changes: make(chan struct{}, 1)}
+	if err := res.replace(tree); err != nil {
+		panic(err)
+	}
+	return res
+}
+
+func (f *Fake) replace(tree interface{}) error {
+	if s, ok := tree.(string); ok {
+		var err error
+		if tree, err = file.Parse(".yaml", []byte(s)); err != nil {
+			return err
+		}
+	}
+	switch v := tree.(type) {
+	case nil:
+		tree = make(map[string]interface{})
+	case map[string]interface{}:
+		tree = copyTree(v)
+	default:
+		return loader.Errors.Errorf("invalid tree type %T", tree)
+	}
+	f.lock.Lock()
+	f.tree = tree.(map[string]interface{})
+	f.lock.Unlock()
+	return nil
+}
+
+// Replace sets the whole tree as in NewFake and emits change event
+func (f *Fake) Replace(tree interface{}) error {
+	if err := f.replace(tree); err != nil {
+		return err
+	}
+	f.notify()
+	return nil
+}
+
+// Set puts the value at dot-separated path, creating intermediate maps, and
+// emits change event. The nil value removes the key.
+func (f *Fake) Set(path string, value interface{}) {
+	f.lock.Lock()
+	m := f.tree
+	keys := strings.Split(path, ".")
+	for _, k := range keys[:len(keys)-1] {
+		next, ok := m[k].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			m[k] = next
+		}
+		m = next
+	}
+	last := keys[len(keys)-1]
+	if value == nil {
+		delete(m, last)
+	} else {
+		m[last] = value
+	}
+	f.lock.Unlock()
+	f.notify()
+}
+
+// FailNext makes the next n Load calls fail with the error, which is wrapped
+// into loader.Errors class
+func (f *Fake) FailNext(n int, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for i := 0; i < n; i++ {
+		f.errs = append(f.errs, loader.Errors.Wrap(err, "injected error"))
+	}
+}
+
+// Notify emits change event without changing the tree
+func (f *Fake) Notify() {
+	f.notify()
+}
+
+// notify emits change event, unless there is one pending already
+func (f *Fake) notify() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.closed {
+		return
+	}
+	select {
+	case f.changes <- struct{}{}:
+	default:
+	}
+}
+
+// Load decodes the tree into dest, or returns injected error
+func (f *Fake) Load(dest interface{}) error {
+	f.lock.Lock()
+	f.loads = append(f.loads, dest)
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		f.lock.Unlock()
+		return err
+	}
+	tree := copyTree(f.tree)
+	f.lock.Unlock()
+	return libkv.Decode(tree, dest)
+}
+
+// LoadCalls returns the targets of all Load calls made so far
+func (f *Fake) LoadCalls() []interface{} {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]interface{}(nil), f.loads...)
+}
+
+// Changes provides the events emitted by Set, Replace and Notify. The
+// events are coalesced if not consumed.
+func (f *Fake) Changes() <-chan struct{} {
+	return f.changes
+}
+
+// Close closes changes channel. It may be called more than once.
+func (f *Fake) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !f.closed {
+		f.closed = true
+		close(f.changes)
+	}
+	return nil
+}
+
+// copyTree makes deep copy of maps and slices, since decoding modifies the
+// tree
+func copyTree(tree interface{}) interface{} {
+	switch v := tree.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			res[k] = copyTree(val)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, val := range v {
+			res[i] = copyTree(val)
+		}
+		return res
+	}
+	return tree
+}
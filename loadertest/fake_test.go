@@ -0,0 +1,77 @@
+package loadertest_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/loadertest"
+)
+
+type testConfig struct {
+	Name    string
+	Timeout time.Duration
+	DB      struct {
+		Hosts []string
+		Port  int
+	}
+}
+
+func TestFake(t *testing.T) {
+	f := loadertest.NewFake("name: x\ntimeout: 1s\ndb:\n  hosts: [a, b]\n  port: 5432\n")
+	defer f.Close()
+	var dest testConfig
+	if err := f.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dest.Name != "x" || dest.Timeout != time.Second || len(dest.DB.Hosts) != 2 || dest.DB.Port != 5432 {
+		t.Errorf("invalid result %+v", dest)
+	}
+	f.Set("db.port", "5433")
+	f.Set("name", "y")
+	select {
+	case <-f.Changes():
+	default:
+		t.Fatal("change expected")
+	}
+	select {
+	case <-f.Changes():
+		t.Fatal("changes must be coalesced")
+	default:
+	}
+	if err := f.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dest.Name != "y" || dest.DB.Port != 5433 {
+		t.Errorf("invalid result %+v", dest)
+	}
+	injected := errors.New("unavailable")
+	f.FailNext(2, injected)
+	for i := 0; i < 2; i++ {
+		if err := f.Load(&dest); !loader.Errors.Contains(err) {
+			t.Errorf("expecting injected error, got %v", err)
+		}
+	}
+	if err := f.Replace(map[string]interface{}{"name": "z"}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var replaced testConfig
+	if err := f.Load(&replaced); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if replaced.Name != "z" || replaced.DB.Port != 0 {
+		t.Errorf("invalid result %+v", replaced)
+	}
+	if calls := f.LoadCalls(); len(calls) != 5 || calls[4] != &replaced {
+		t.Errorf("invalid calls %v", calls)
+	}
+	if err := f.Replace(42); err == nil {
+		t.Error("expecting invalid tree error")
+	}
+	f.Close()
+	f.Close()
+	for range f.Changes() {
+		// the pending event is delivered before the channel gets closed
+	}
+}
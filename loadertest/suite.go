@@ -0,0 +1,235 @@
+package loadertest
+
+import (
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/go-mixins/loader"
+)
+
+// Timeout limits waiting for change events and goroutines to finish
+var Timeout = 5 * time.Second
+
+// Config is the configuration the suite stores and loads back
+type Config struct {
+	Name    string
+	Port    int
+	Ratio   float64
+	Enabled bool
+	Timeout time.Duration
+	Addr    net.IP
+	Tags    []string
+	Labels  map[string]string
+	DB      struct {
+		Host     string
+		MaxConns int
+	}
+}
+
+// Subject is the loader implementation under test
+type Subject struct {
+	// Store puts the object into the source in the format of the loader.
+	// The object is either *Config or some other struct pointer.
+	Store func(src interface{}) error
+	// New creates the loader reading from the source
+	New func() loader.Loader
+	// Watch is set if the loader reports changes of the source
+	Watch bool
+	// Debounce is set if the loader reports a burst of source changes
+	// with single event
+	Debounce bool
+}
+
+// Factory prepares the source for the loader under test. It is called for
+// every test case.
+type Factory func(t *testing.T) Subject
+
+func sample() *Config {
+	res := &Config{
+		Name:    "app",
+		Port:    8080,
+		Ratio:   0.5,
+		Enabled: true,
+		Timeout: 1500 * time.Millisecond,
+		Addr:    net.ParseIP("10.0.0.1"),
+		Tags:    []string{"a", "b"},
+		Labels:  map[string]string{"env": "test", "zone": "a"},
+	}
+	res.DB.Host = "db"
+	res.DB.MaxConns = 10
+	return res
+}
+
+// Run executes the conformance suite against loader implementation. The
+// loaders implementing io.Closer are closed after use.
+func Run(t *testing.T, factory Factory) {
+	t.Run("Decode", func(t *testing.T) { testDecode(t, factory(t)) })
+	t.Run("Changes", func(t *testing.T) { testChanges(t, factory(t)) })
+	t.Run("Debounce", func(t *testing.T) { testDebounce(t, factory(t)) })
+	t.Run("Close", func(t *testing.T) { testClose(t, factory(t)) })
+	t.Run("Errors", func(t *testing.T) { testErrors(t, factory(t)) })
+}
+
+func closeLoader(t *testing.T, l loader.Loader) {
+	if c, ok := l.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			t.Errorf("closing loader: %+v", err)
+		}
+	}
+}
+
+func store(t *testing.T, s Subject, src interface{}) {
+	if err := s.Store(src); err != nil {
+		t.Fatalf("storing source: %+v", err)
+	}
+}
+
+func load(t *testing.T, l loader.Loader, expect *Config) {
+	var dest Config
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if diff := deep.Equal(expect, &dest); diff != nil {
+		t.Errorf("%+v", diff)
+	}
+}
+
+func testDecode(t *testing.T, s Subject) {
+	store(t, s, sample())
+	l := s.New()
+	defer closeLoader(t, l)
+	load(t, l, sample())
+}
+
+func waitChange(t *testing.T, l loader.Loader) {
+	select {
+	case _, ok := <-l.Changes():
+		if !ok {
+			t.Fatal("changes channel closed")
+		}
+	case <-time.After(Timeout):
+		t.Fatal("timed out waiting for change")
+	}
+}
+
+// drain consumes the events until there are none during settle time
+func drain(l loader.Loader, settle time.Duration) (n int) {
+	for {
+		select {
+		case _, ok := <-l.Changes():
+			if !ok {
+				return
+			}
+			n++
+		case <-time.After(settle):
+			return
+		}
+	}
+}
+
+func testChanges(t *testing.T, s Subject) {
+	if !s.Watch {
+		t.Skip("changes are not reported")
+	}
+	store(t, s, sample())
+	l := s.New()
+	defer closeLoader(t, l)
+	load(t, l, sample())
+	// Some loaders report the initial state
+	drain(l, Timeout/10)
+	changed := sample()
+	changed.Name = "changed"
+	changed.DB.MaxConns = 20
+	store(t, s, changed)
+	waitChange(t, l)
+	drain(l, Timeout/10)
+	load(t, l, changed)
+}
+
+func testDebounce(t *testing.T, s Subject) {
+	if !s.Watch {
+		t.Skip("changes are not reported")
+	}
+	store(t, s, sample())
+	l := s.New()
+	defer closeLoader(t, l)
+	load(t, l, sample())
+	drain(l, Timeout/10)
+	const burst = 5
+	src := sample()
+	for i := 1; i <= burst; i++ {
+		src.Port = 8080 + i
+		store(t, s, src)
+	}
+	waitChange(t, l)
+	n := 1 + drain(l, Timeout/5)
+	if s.Debounce && n >= burst {
+		t.Errorf("%d changes reported for burst of %d", n, burst)
+	}
+	load(t, l, src)
+}
+
+func testClose(t *testing.T, s Subject) {
+	store(t, s, sample())
+	before := runtime.NumGoroutine()
+	l := s.New()
+	if err := l.Load(new(Config)); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	c, ok := l.(io.Closer)
+	if !ok {
+		t.Skip("loader does not implement io.Closer")
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("%+v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("second Close: %+v", err)
+	}
+	if changes := l.Changes(); changes != nil {
+		timeout := time.After(Timeout)
+		for open := true; open; {
+			select {
+			case _, open = <-changes:
+			case <-timeout:
+				t.Error("changes channel must be closed")
+				open = false
+			}
+		}
+	}
+	deadline := time.Now().Add(Timeout)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Errorf("goroutines leaked: %d before, %d after Close\n%s",
+				before, runtime.NumGoroutine(), buf[:runtime.Stack(buf, true)])
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testErrors(t *testing.T, s Subject) {
+	var invalid struct {
+		Port string
+	}
+	invalid.Port = "not a number"
+	store(t, s, &invalid)
+	l := s.New()
+	defer closeLoader(t, l)
+	err := l.Load(new(Config))
+	if err == nil {
+		t.Fatal("expecting decoding error")
+	}
+	if !loader.Errors.Contains(err) {
+		t.Errorf("error must belong to loader.Errors: %+v", err)
+	}
+	if err = l.Load(Config{}); err == nil || !loader.Errors.Contains(err) {
+		t.Errorf("expecting loader.Errors for non-pointer target, got %+v", err)
+	}
+}
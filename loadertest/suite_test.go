@@ -0,0 +1,28 @@
+package loadertest_test
+
+import (
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/loadertest"
+)
+
+func TestRun(t *testing.T) {
+	loadertest.Run(t, func(t *testing.T) loadertest.Subject {
+		f := loadertest.NewFake(nil)
+		return loadertest.Subject{
+			Store: func(src interface{}) error {
+				data, err := yaml.Marshal(src)
+				if err != nil {
+					return err
+				}
+				return f.Replace(string(data))
+			},
+			New:      func() loader.Loader { return f },
+			Watch:    true,
+			Debounce: true,
+		}
+	})
+}
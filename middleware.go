@@ -0,0 +1,13 @@
+package loader
+
+// Middleware wraps Loader adding some behavior to it
+type Middleware func(Loader) Loader
+
+// Chain wraps the loader with middlewares, so that the first one is the
+// outermost
+func Chain(l Loader, mw ...Middleware) Loader {
+	for i := len(mw) - 1; i >= 0; i-- {
+		l = mw[i](l)
+	}
+	return l
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"reflect"
+
+	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/libkv"
+)
+
+// Defaults sets the fields having `default` tag before loading, so that the
+// values missing in the source keep the defaults. The tag values are decoded
+// following the rules of libkv loader. The pointers to structs having
+// defaults are allocated.
+func Defaults() loader.Middleware {
+	return LoadFunc(func(dest interface{}, next func(interface{}) error) error {
+		v, ok := structValue(dest)
+		if !ok {
+			return next(dest)
+		}
+		if tree := defaults(v.Type()); len(tree) > 0 {
+			if err := libkv.Decode(tree, dest); err != nil {
+				return loader.DecodeErrors.Wrap(err, "setting defaults")
+			}
+		}
+		return next(dest)
+	})
+}
+
+// defaults builds the tree of default values for the struct type
+func defaults(t reflect.Type) map[string]interface{} {
+	res := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		key, squash := libkv.Lowercase.Key(f)
+		if key == "-" {
+			continue
+		}
+		var val interface{}
+		if def, ok := f.Tag.Lookup("default"); ok {
+			val = def
+		} else if ft := indirect(f.Type); ft.Kind() == reflect.Struct {
+			sub := defaults(ft)
+			if len(sub) == 0 {
+				continue
+			}
+			if squash {
+				for k, v := range sub {
+					res[k] = v
+				}
+				continue
+			}
+			val = sub
+		} else {
+			continue
+		}
+		res[key] = val
+	}
+	return res
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
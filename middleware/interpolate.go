@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/go-mixins/loader"
+)
+
+// Interpolate expands ${VAR} and $VAR references in the string values after
+// loading, including the ones in slices and maps, "$$" standing for single
+// "$". The secret fields are left intact, the same ones Log redacts: tagged
+// `secret:"true"` or named with one of SecretNames, including map keys. The
+// variables are looked up with the function, os.LookupEnv if nil. Undefined
+// variables are errors.
+func Interpolate(lookup func(name string) (string, bool)) loader.Middleware {
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	return LoadFunc(func(dest interface{}, next func(interface{}) error) error {
+		if err := next(dest); err != nil {
+			return err
+		}
+		return expandValue(reflect.ValueOf(dest), lookup)
+	})
+}
+
+func expand(s string, lookup func(string) (string, bool)) (string, error) {
+	var undefined []string
+	res := os.Expand(s, func(name string) string {
+		if name == "$" {
+			return name
+		}
+		val, ok := lookup(name)
+		if !ok {
+			undefined = append(undefined, name)
+		}
+		return val
+	})
+	if len(undefined) > 0 {
+		// the value itself may be secret, so it is not reported
+		return "", loader.DecodeErrors.Errorf("undefined variables %q", undefined)
+	}
+	return res, nil
+}
+
+func expandValue(v reflect.Value, lookup func(string) (string, bool)) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Kind() == reflect.Interface {
+			// the value inside interface is not addressable
+			if s, ok := v.Interface().(string); ok && v.CanSet() {
+				res, err := expand(s, lookup)
+				if err == nil {
+					v.Set(reflect.ValueOf(res))
+				}
+				return err
+			}
+		}
+		return expandValue(v.Elem(), lookup)
+	case reflect.String:
+		if !v.CanSet() {
+			return nil
+		}
+		res, err := expand(v.String(), lookup)
+		if err != nil {
+			return err
+		}
+		v.SetString(res)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Type().Field(i); f.PkgPath != "" || isSecret(f) {
+				continue
+			}
+			if err := expandValue(v.Field(i), lookup); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := expandValue(v.Index(i), lookup); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			if isSecretName(fmt.Sprint(k.Interface())) {
+				continue
+			}
+			// map values are not addressable, so they are copied
+			val := reflect.New(v.Type().Elem()).Elem()
+			val.Set(v.MapIndex(k))
+			if err := expandValue(val, lookup); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, val)
+		}
+	}
+	return nil
+}
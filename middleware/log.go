@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-mixins/loader"
+)
+
+// Redacted replaces the values of secret fields in the log
+const Redacted = "[REDACTED]"
+
+// SecretNames are the parts of field names and map keys considered secret
+// regardless of the `secret:"true"` tag
+var SecretNames = []string{"password", "secret", "token"}
+
+// Log reports every loading with the function, like log.Printf. The loaded
+// configuration is logged as JSON with secret fields redacted.
+func Log(logf func(format string, args ...interface{})) loader.Middleware {
+	return LoadFunc(func(dest interface{}, next func(interface{}) error) error {
+		if err := next(dest); err != nil {
+			logf("configuration loading failed: %v", err)
+			return err
+		}
+		data, err := json.Marshal(redact(reflect.ValueOf(dest)))
+		if err != nil {
+			data = []byte(err.Error())
+		}
+		logf("configuration loaded: %s", data)
+		return nil
+	})
+}
+
+// isSecret reports if the field is tagged `secret:"true"` or has secret name
+func isSecret(f reflect.StructField) bool {
+	return hasSecretTag(f) || isSecretName(f.Name)
+}
+
+func hasSecretTag(f reflect.StructField) bool {
+	return f.Tag.Get("secret") == "true"
+}
+
+func isSecretName(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range SecretNames {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// redact converts the value into generic tree without secrets
+func redact(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Type().Implements(textMarshalerType) {
+		return v.Interface()
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return redact(v.Elem())
+	case reflect.Struct:
+		res := make(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			switch {
+			case f.PkgPath != "":
+			case isSecret(f):
+				res[f.Name] = Redacted
+			default:
+				res[f.Name] = redact(v.Field(i))
+			}
+		}
+		return res
+	case reflect.Map:
+		res := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			name := fmt.Sprint(k.Interface())
+			if isSecretName(name) {
+				res[name] = Redacted
+				continue
+			}
+			res[name] = redact(v.MapIndex(k))
+		}
+		return res
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		res := make([]interface{}, v.Len())
+		for i := range res {
+			res[i] = redact(v.Index(i))
+		}
+		return res
+	}
+	return v.Interface()
+}
@@ -0,0 +1,48 @@
+// Package middleware provides loader.Middleware wrappers implementing the
+// behavior common to all loaders
+package middleware
+
+import (
+	"io"
+	"reflect"
+
+	"github.com/go-mixins/loader"
+)
+
+// wrapped replaces Load of the original loader, keeping its Changes and Close
+type wrapped struct {
+	loader.Loader
+	load func(dest interface{}) error
+}
+
+func (w *wrapped) Load(dest interface{}) error {
+	return w.load(dest)
+}
+
+// Close closes the original loader if it implements io.Closer
+func (w *wrapped) Close() error {
+	if c, ok := w.Loader.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// LoadFunc makes Middleware out of the function wrapping Load. The next
+// function calls Load of the wrapped loader.
+func LoadFunc(f func(dest interface{}, next func(dest interface{}) error) error) loader.Middleware {
+	return func(l loader.Loader) loader.Loader {
+		return &wrapped{Loader: l, load: func(dest interface{}) error {
+			return f(dest, l.Load)
+		}}
+	}
+}
+
+// structValue returns the struct the target points to, if any
+func structValue(dest interface{}) (reflect.Value, bool) {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return v, false
+	}
+	v = v.Elem()
+	return v, v.Kind() == reflect.Struct
+}
@@ -0,0 +1,203 @@
+package middleware_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/loadertest"
+	"github.com/go-mixins/loader/middleware"
+)
+
+type testConfig struct {
+	Name    string
+	Timeout time.Duration `default:"5s"`
+	DB      struct {
+		Host     string `default:"localhost"`
+		Port     int    `default:"5432"`
+		Password string
+	}
+	APIKey string `secret:"true"`
+	Hosts  []string
+	Labels map[string]string
+}
+
+func (c *testConfig) Validate() error {
+	if c.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+func TestChain(t *testing.T) {
+	var (
+		logs   []string
+		timing []time.Duration
+	)
+	f := loadertest.NewFake(`
+name: ${APP}-service
+db:
+  port: 5433
+  password: pass
+apikey: key
+hosts: [$HOST]
+labels:
+  zone: $ZONE
+`)
+	vars := map[string]string{"APP": "app", "HOST": "h1", "ZONE": "z1"}
+	l := loader.Chain(f,
+		middleware.Timing(func(d time.Duration, err error) { timing = append(timing, d) }),
+		middleware.Log(func(format string, args ...interface{}) { logs = append(logs, fmt.Sprintf(format, args...)) }),
+		middleware.Retry(3, time.Millisecond),
+		middleware.Validate(nil),
+		middleware.Interpolate(func(name string) (string, bool) {
+			val, ok := vars[name]
+			return val, ok
+		}),
+		middleware.Defaults(),
+	)
+	f.FailNext(2, errors.New("unavailable"))
+	var dest testConfig
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dest.Name != "app-service" || dest.Timeout != 5*time.Second || dest.DB.Host != "localhost" ||
+		dest.DB.Port != 5433 || dest.Hosts[0] != "h1" || dest.Labels["zone"] != "z1" {
+		t.Errorf("invalid result %+v", dest)
+	}
+	if len(f.LoadCalls()) != 3 || len(timing) != 1 || len(logs) != 1 {
+		t.Errorf("invalid calls: %d loads, %d timings, %d logs", len(f.LoadCalls()), len(timing), len(logs))
+	}
+	if strings.Contains(logs[0], "pass") || strings.Contains(logs[0], `"key"`) || !strings.Contains(logs[0], "app-service") {
+		t.Errorf("secrets must be redacted: %s", logs[0])
+	}
+
+	f.Replace("db:\n  host: db\n")
+	if err := l.Load(&testConfig{}); err == nil || !loader.Errors.Contains(err) {
+		t.Errorf("expecting validation error, got %v", err)
+	}
+	f.Replace("name: $UNDEFINED\n")
+	if err := l.Load(&testConfig{}); err == nil || !strings.Contains(err.Error(), "UNDEFINED") {
+		t.Errorf("expecting interpolation error, got %v", err)
+	}
+	if len(logs) != 3 || !strings.Contains(logs[2], "failed") {
+		t.Errorf("invalid logs %q", logs)
+	}
+	select {
+	case <-l.Changes():
+	default:
+		t.Error("changes must be passed through")
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	var dest struct {
+		Price  string
+		Host   string
+		APIKey string `secret:"true"`
+		Token  string
+		Labels map[string]string
+	}
+	l := loader.Chain(loadertest.NewFake("price: $$5 per $UNIT\nhost: ${HOST}\napikey: pa$$word\ntoken: ${TOKEN}\nlabels:\n  zone: $HOST\n  db_password: pa$$word\n"),
+		middleware.Interpolate(func(name string) (string, bool) {
+			val, ok := map[string]string{"UNIT": "item", "HOST": "h", "TOKEN": "t"}[name]
+			return val, ok
+		}),
+	)
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dest.Price != "$5 per item" || dest.Host != "h" || dest.APIKey != "pa$$word" || dest.Token != "${TOKEN}" {
+		t.Errorf("invalid result %+v", dest)
+	}
+	if dest.Labels["zone"] != "h" || dest.Labels["db_password"] != "pa$$word" {
+		t.Errorf("invalid labels %+v", dest.Labels)
+	}
+	l = loader.Chain(loadertest.NewFake("url: s3cr3t$MISSING\n"), middleware.Interpolate(func(string) (string, bool) {
+		return "", false
+	}))
+	err := l.Load(&struct{ URL string }{})
+	if err == nil || !strings.Contains(err.Error(), "MISSING") || strings.Contains(err.Error(), "s3cr3t") {
+		t.Errorf("expecting error without the value, got %v", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		data        string
+		fail, loads int
+	}{
+		{"Decode", "db:\n  port: x\n", 0, 1},
+		{"Validate", "db:\n  port: 1\n", 0, 1},
+		{"Crypt", "name: ENC[AES256_GCM,data:AA==,iv:AA==,tag:AA==,type:str]\n", 0, 1},
+		{"Source", "name: x\n", 2, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := loadertest.NewFake(tc.data)
+			f.FailNext(tc.fail, errors.New("unavailable"))
+			l := loader.Chain(f, middleware.Retry(3, time.Millisecond), middleware.Validate(nil))
+			err := l.Load(&testConfig{})
+			if (tc.fail == 0) != (err != nil) {
+				t.Errorf("unexpected result: %v", err)
+			}
+			if n := len(f.LoadCalls()); n != tc.loads {
+				t.Errorf("expected %d loads, got %d", tc.loads, n)
+			}
+		})
+	}
+}
+
+func TestRetry_MaxWait(t *testing.T) {
+	defer func(wait time.Duration) { middleware.MaxRetryWait = wait }(middleware.MaxRetryWait)
+	middleware.MaxRetryWait = 25 * time.Millisecond
+	f := loadertest.NewFake("name: x\n")
+	f.FailNext(10, errors.New("unavailable"))
+	l := loader.Chain(f, middleware.Retry(10, 10*time.Millisecond))
+	start := time.Now()
+	if err := l.Load(&testConfig{}); err == nil {
+		t.Error("expecting error")
+	}
+	// The delays of 10ms and then 15ms left fit into MaxRetryWait
+	if n := len(f.LoadCalls()); n != 3 {
+		t.Errorf("expected 3 loads, got %d", n)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("waited too long: %v", d)
+	}
+}
+
+func TestLog_MapKeys(t *testing.T) {
+	var logs []string
+	l := loader.Chain(loadertest.NewFake("labels:\n  zone: z1\n  db_password: pass\n"),
+		middleware.Log(func(format string, args ...interface{}) { logs = append(logs, fmt.Sprintf(format, args...)) }),
+	)
+	if err := l.Load(&testConfig{}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if strings.Contains(logs[0], "pass\"") || !strings.Contains(logs[0], "z1") {
+		t.Errorf("secrets must be redacted: %s", logs[0])
+	}
+}
+
+func TestDefaults_Pointer(t *testing.T) {
+	var dest struct {
+		DB *struct {
+			Host string `default:"localhost"`
+			Port int    `default:"5432"`
+		}
+		Cache *struct {
+			Host string
+		}
+	}
+	l := loader.Chain(loadertest.NewFake("db:\n  port: 5433\n"), middleware.Defaults())
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dest.DB == nil || dest.DB.Host != "localhost" || dest.DB.Port != 5433 || dest.Cache != nil {
+		t.Errorf("invalid result %+v", dest)
+	}
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/go-mixins/loader"
+)
+
+// MaxRetryWait limits the total time Retry waits between attempts of single
+// loading
+var MaxRetryWait = time.Minute
+
+// Retry repeats failed loading up to the number of attempts in total. The
+// delay between attempts starts with the specified one and doubles every
+// time, and the loading gives up once MaxRetryWait is spent waiting. Only the
+// failures of the source are retried, loader.DecodeErrors and
+// ValidationErrors are returned right away.
+func Retry(attempts int, delay time.Duration) loader.Middleware {
+	return LoadFunc(func(dest interface{}, next func(interface{}) error) (err error) {
+		var waited time.Duration
+		for i := 0; i < attempts || i == 0; i++ {
+			if i > 0 {
+				left := MaxRetryWait - waited
+				if left <= 0 {
+					break
+				}
+				if delay > left {
+					delay = left
+				}
+				time.Sleep(delay)
+				waited += delay
+				delay *= 2
+			}
+			err = next(dest)
+			if err == nil || loader.DecodeErrors.Contains(err) || ValidationErrors.Contains(err) {
+				return err
+			}
+		}
+		return err
+	})
+}
+
+// Timing reports the duration and the result of every loading to the
+// function, like metrics histogram observer
+func Timing(observe func(d time.Duration, err error)) loader.Middleware {
+	return LoadFunc(func(dest interface{}, next func(interface{}) error) error {
+		start := time.Now()
+		err := next(dest)
+		observe(time.Since(start), err)
+		return err
+	})
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"github.com/go-mixins/loader"
+)
+
+// ValidationErrors are the errors of Validate
+var ValidationErrors = loader.Errors.Sub("validate")
+
+// Validator is implemented by the targets validating themselves
+type Validator interface {
+	Validate() error
+}
+
+// Validate checks the target after successful loading, calling the
+// function, if any, and the Validate method if the target implements
+// Validator
+func Validate(f func(dest interface{}) error) loader.Middleware {
+	return LoadFunc(func(dest interface{}, next func(interface{}) error) error {
+		if err := next(dest); err != nil {
+			return err
+		}
+		if f != nil {
+			if err := f(dest); err != nil {
+				return ValidationErrors.Wrap(err, "validating configuration")
+			}
+		}
+		if v, ok := dest.(Validator); ok {
+			return ValidationErrors.Wrap(v.Validate(), "validating configuration")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,350 @@
+// Package usage documents configuration fields as seen by every loader
+package usage
+
+import (
+	"encoding"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/env"
+	"github.com/go-mixins/loader/libkv"
+)
+
+// Field describes configuration field. The fields of slice and map
+// elements are listed with "<index>" and "<key>" placeholders in place of
+// the element index or map key, like APP_BACKENDS_<index>_HOST or
+// backends.<index>.host.
+type Field struct {
+	// Name is the dotted path of Go struct fields
+	Name string
+	// Env is the environment variable name, with the alternative name
+	// after the comma, if any. Empty if the field is ignored by env loader.
+	Env string
+	// YAML and JSON are the dotted key paths in the documents. Empty if
+	// the field is skipped by the format.
+	YAML, JSON string
+	// KV is the key in KV store. The keys are matched case-insensitively,
+	// unless the naming is case-sensitive.
+	KV       string
+	Type     string
+	Default  string
+	Required bool
+	Desc     string
+}
+
+// Placeholders of slice index and map key in the names of element fields
+const (
+	IndexPlaceholder = "<index>"
+	KeyPlaceholder   = "<key>"
+)
+
+// Config specifies the loader settings the field names are computed for
+type Config struct {
+	// EnvPrefix and EnvNaming of env loader. The naming is env.Envconfig
+	// if not set.
+	EnvPrefix string
+	EnvNaming env.Naming
+	// KVPrefix and KVNaming of libkv loader. The naming is libkv.Lowercase
+	// if not set.
+	KVPrefix string
+	KVNaming libkv.Naming
+}
+
+// Fields lists configuration fields of the spec, which must be a pointer to
+// struct. The field names are computed for env loader with envPrefix and
+// libkv loader with kvPrefix, both with default naming.
+func Fields(spec interface{}, envPrefix, kvPrefix string) ([]Field, error) {
+	return FieldsWith(spec, &Config{EnvPrefix: envPrefix, KVPrefix: kvPrefix})
+}
+
+// FieldsWith lists configuration fields of the spec, which must be a pointer
+// to struct, computing the names for the loaders configured with cfg. The
+// config may be nil.
+func FieldsWith(spec interface{}, cfg *Config) ([]Field, error) {
+	t := reflect.TypeOf(spec)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return nil, loader.Errors.New("spec must be a struct pointer")
+	}
+	var c Config
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.EnvNaming.Name == nil {
+		c.EnvNaming = env.Envconfig
+	}
+	if c.KVNaming.Name == nil {
+		c.KVNaming = libkv.Lowercase
+	}
+	return c.fields(t.Elem(), c.EnvPrefix, nil)
+}
+
+// step is the struct field or element placeholder on the path to the field
+type step struct {
+	field reflect.StructField
+	elem  string
+}
+
+// fields lists the fields of struct type, which is found by the path from
+// the spec
+func (c *Config) fields(t reflect.Type, envPrefix string, path []step) ([]Field, error) {
+	vars, err := c.EnvNaming.Vars(envPrefix, reflect.New(t).Interface())
+	if err != nil {
+		return nil, err
+	}
+	byPath := make(map[string]env.Var, len(vars))
+	for _, v := range vars {
+		byPath[pathKey(v.Path)] = v
+	}
+	return c.structFields(t, path, nil, byPath)
+}
+
+// structFields lists the fields of struct type found by the path from the
+// spec and by rel from the type the env variables are listed for. The fields
+// ignored by env loader have empty Env.
+func (c *Config) structFields(t reflect.Type, path []step, rel []reflect.StructField, vars map[string]env.Var) ([]Field, error) {
+	var res []Field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		rel := append(rel[:len(rel):len(rel)], sf)
+		full := append(path[:len(path):len(path)], step{field: sf})
+		v, hasVar := vars[pathKey(rel)]
+		ft := indirect(sf.Type)
+		switch {
+		case hasVar && v.Indexed || !hasVar && isIndexed(ft):
+			placeholder := IndexPlaceholder
+			if ft.Kind() == reflect.Map {
+				placeholder = KeyPlaceholder
+			}
+			elem := indirect(ft.Elem())
+			elemPath := append(full, step{elem: placeholder})
+			var (
+				sub []Field
+				err error
+			)
+			if hasVar {
+				sub, err = c.fields(elem, "", elemPath)
+			} else {
+				sub, err = c.structFields(elem, elemPath, nil, nil)
+			}
+			if err != nil {
+				return nil, err
+			}
+			for j := range sub {
+				if hasVar && sub[j].Env != "" {
+					sub[j].Env = v.Key + c.EnvNaming.Separator + placeholder + c.EnvNaming.Separator + sub[j].Env
+				}
+			}
+			res = append(res, sub...)
+			continue
+		case !hasVar && isStruct(ft):
+			sub, err := c.structFields(ft, full, rel, vars)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, sub...)
+			continue
+		}
+		f := Field{
+			YAML:     yamlKey(full),
+			JSON:     jsonKey(full),
+			KV:       c.kvKey(full),
+			Type:     typeName(sf.Type),
+			Default:  sf.Tag.Get("default"),
+			Required: sf.Tag.Get("required") == "true",
+			Desc:     sf.Tag.Get("desc"),
+		}
+		if hasVar {
+			f.Env = v.Key
+			if v.Alt != "" && v.Alt != v.Key {
+				f.Env += ", " + v.Alt
+			}
+		}
+		names := make([]string, len(full))
+		for j := range full {
+			names[j] = full[j].name()
+		}
+		f.Name = strings.Join(names, ".")
+		res = append(res, f)
+	}
+	return res, nil
+}
+
+func pathKey(path []reflect.StructField) string {
+	names := make([]string, len(path))
+	for i := range path {
+		names[i] = path[i].Name
+	}
+	return strings.Join(names, ".")
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// isStruct reports if the fields of the struct are listed separately
+func isStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && !reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+// isIndexed reports if the element fields of slice or map are listed
+func isIndexed(t reflect.Type) bool {
+	return (t.Kind() == reflect.Slice || t.Kind() == reflect.Map) && isStruct(indirect(t.Elem()))
+}
+
+func (s step) name() string {
+	if s.elem != "" {
+		return s.elem
+	}
+	return s.field.Name
+}
+
+func tagName(tag string) (name string, opts []string) {
+	parts := strings.Split(tag, ",")
+	return parts[0], parts[1:]
+}
+
+func hasOpt(opts []string, opt string) bool {
+	for _, o := range opts {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// yamlKey follows the rules of gopkg.in/yaml.v2
+func yamlKey(path []step) string {
+	var res []string
+	for _, s := range path {
+		if s.elem != "" {
+			res = append(res, s.elem)
+			continue
+		}
+		name, opts := tagName(s.field.Tag.Get("yaml"))
+		switch {
+		case name == "-":
+			return ""
+		case hasOpt(opts, "inline"):
+			continue
+		case name == "":
+			name = strings.ToLower(s.field.Name)
+		}
+		res = append(res, name)
+	}
+	return strings.Join(res, ".")
+}
+
+// jsonKey follows the rules of encoding/json
+func jsonKey(path []step) string {
+	var res []string
+	for _, s := range path {
+		if s.elem != "" {
+			res = append(res, s.elem)
+			continue
+		}
+		name, _ := tagName(s.field.Tag.Get("json"))
+		switch {
+		case name == "-":
+			return ""
+		case name == "" && s.field.Anonymous:
+			continue
+		case name == "":
+			name = s.field.Name
+		}
+		res = append(res, name)
+	}
+	return strings.Join(res, ".")
+}
+
+// kvKey follows the rules of libkv loader
+func (c *Config) kvKey(path []step) string {
+	res := []string{strings.Trim(c.KVPrefix, "/")}
+	if res[0] == "" {
+		res = nil
+	}
+	for _, s := range path {
+		if s.elem != "" {
+			res = append(res, s.elem)
+			continue
+		}
+		name, squash := c.KVNaming.Key(s.field)
+		switch {
+		case name == "-":
+			return ""
+		case squash:
+			continue
+		}
+		res = append(res, name)
+	}
+	return strings.Join(res, "/")
+}
+
+func typeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Name() != "" {
+		return t.String()
+	}
+	switch t.Kind() {
+	case reflect.Slice:
+		return "[]" + typeName(t.Elem())
+	case reflect.Map:
+		return fmt.Sprintf("map[%s]%s", typeName(t.Key()), typeName(t.Elem()))
+	}
+	return t.String()
+}
+
+// Markdown writes the table of fields
+func Markdown(w io.Writer, fields []Field) error {
+	esc := strings.NewReplacer("|", `\|`, "\n", " ")
+	code := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return "`" + esc.Replace(s) + "`"
+	}
+	if _, err := fmt.Fprintln(w, "| Environment | YAML | JSON | KV | Type | Default | Required | Description |\n|---|---|---|---|---|---|---|---|"); err != nil {
+		return loader.Errors.Wrap(err, "writing header")
+	}
+	for _, f := range fields {
+		required := ""
+		if f.Required {
+			required = "yes"
+		}
+		_, err := fmt.Fprintf(w, "| %s | %s | %s | %s | %s | %s | %s | %s |\n",
+			code(f.Env), code(f.YAML), code(f.JSON), code(f.KV), code(f.Type),
+			code(f.Default), required, esc.Replace(f.Desc))
+		if err != nil {
+			return loader.Errors.Wrapf(err, "writing %s", f.Name)
+		}
+	}
+	return nil
+}
+
+// Text writes the fields as plain text columns
+func Text(w io.Writer, fields []Field) error {
+	tw := tabwriter.NewWriter(w, 1, 0, 4, ' ', 0)
+	fmt.Fprintln(tw, "ENVIRONMENT\tYAML\tJSON\tKV\tTYPE\tDEFAULT\tREQUIRED\tDESCRIPTION")
+	for _, f := range fields {
+		required := ""
+		if f.Required {
+			required = "true"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			f.Env, f.YAML, f.JSON, f.KV, f.Type, f.Default, required, f.Desc)
+	}
+	return loader.Errors.Wrap(tw.Flush(), "writing fields")
+}
@@ -0,0 +1,98 @@
+package usage_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"github.com/go-mixins/loader/env"
+	"github.com/go-mixins/loader/libkv"
+	"github.com/go-mixins/loader/usage"
+)
+
+type Common struct {
+	Debug bool `desc:"enable debug | trace"`
+}
+
+type testConfig struct {
+	Common   `yaml:",inline" mapstructure:",squash"`
+	LogLevel string        `split_words:"true" yaml:"log_level" json:"logLevel" default:"info"`
+	Timeout  time.Duration `envconfig:"TIMEOUT" required:"true" desc:"request timeout"`
+	DB       *struct {
+		Host string `mapstructure:"hostname"`
+	}
+	Tags     []string `json:"-"`
+	Internal string   `ignored:"true"`
+	Backends []struct {
+		MaxConns int
+	}
+}
+
+func TestFields(t *testing.T) {
+	fields, err := usage.Fields(&testConfig{}, "app", "/service/app/")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := []usage.Field{
+		{Name: "Common.Debug", Env: "APP_DEBUG", YAML: "debug", JSON: "Debug", KV: "service/app/debug", Type: "bool", Desc: "enable debug | trace"},
+		{Name: "LogLevel", Env: "APP_LOG_LEVEL", YAML: "log_level", JSON: "logLevel", KV: "service/app/logLevel", Type: "string", Default: "info"},
+		{Name: "Timeout", Env: "APP_TIMEOUT, TIMEOUT", YAML: "timeout", JSON: "Timeout", KV: "service/app/timeout", Type: "time.Duration", Required: true, Desc: "request timeout"},
+		{Name: "DB.Host", Env: "APP_DB_HOST", YAML: "db.host", JSON: "DB.Host", KV: "service/app/db/hostname", Type: "string"},
+		{Name: "Tags", Env: "APP_TAGS", YAML: "tags", Type: "[]string"},
+		{Name: "Internal", YAML: "internal", JSON: "Internal", KV: "service/app/internal", Type: "string"},
+		{Name: "Backends.<index>.MaxConns", Env: "APP_BACKENDS_<index>_MAXCONNS", YAML: "backends.<index>.maxconns", JSON: "Backends.<index>.MaxConns", KV: "service/app/backends/<index>/maxconns", Type: "int"},
+	}
+	if diff := deep.Equal(expect, fields); diff != nil {
+		t.Errorf("%+v", diff)
+	}
+	var buf bytes.Buffer
+	if err = usage.Markdown(&buf, fields); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != len(fields)+2 {
+		t.Errorf("invalid markdown:\n%s", buf.String())
+	} else if !strings.Contains(lines[2], `debug \| trace`) {
+		t.Errorf("pipe must be escaped: %s", lines[2])
+	}
+	buf.Reset()
+	if err = usage.Text(&buf, fields); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !strings.Contains(buf.String(), "APP_TIMEOUT, TIMEOUT") {
+		t.Errorf("invalid text:\n%s", buf.String())
+	}
+	if _, err = usage.Fields(testConfig{}, "", ""); err == nil {
+		t.Error("expecting error for non-pointer spec")
+	}
+}
+
+func TestFieldsWith(t *testing.T) {
+	type config struct {
+		MaxConns int
+		Limits   map[string]*struct {
+			RateLimit int
+		}
+		Hidden struct {
+			Level int
+		} `ignored:"true"`
+	}
+	fields, err := usage.FieldsWith(&config{}, &usage.Config{
+		EnvPrefix: "app",
+		EnvNaming: env.DoubleUnderscore,
+		KVNaming:  libkv.SnakeCase,
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := []usage.Field{
+		{Name: "MaxConns", Env: "APP__MAXCONNS", YAML: "maxconns", JSON: "MaxConns", KV: "max_conns", Type: "int"},
+		{Name: "Limits.<key>.RateLimit", Env: "APP__LIMITS__<key>__RATELIMIT", YAML: "limits.<key>.ratelimit", JSON: "Limits.<key>.RateLimit", KV: "limits/<key>/rate_limit", Type: "int"},
+		{Name: "Hidden.Level", YAML: "hidden.level", JSON: "Hidden.Level", KV: "hidden/level", Type: "int"},
+	}
+	if diff := deep.Equal(expect, fields); diff != nil {
+		t.Errorf("%+v", diff)
+	}
+}
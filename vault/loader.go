@@ -0,0 +1,503 @@
+// Package vault loads configuration from HashiCorp Vault KV secrets engine
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-mixins/loader"
+	"github.com/go-mixins/loader/internal/tlsutil"
+	"github.com/go-mixins/loader/libkv"
+)
+
+// Environment variables consulted when Config leaves the settings empty
+const (
+	AddrEnv  = "VAULT_ADDR"
+	TokenEnv = "VAULT_TOKEN"
+)
+
+// Defaults for Config
+var (
+	DefaultAddress  = "https://127.0.0.1:8200"
+	DefaultInterval = time.Minute
+	DefaultTimeout  = 30 * time.Second
+)
+
+// Config specifies Vault connection settings
+type Config struct {
+	// Address of Vault server, taken from VAULT_ADDR if empty, then
+	// DefaultAddress
+	Address string
+	// Mount path of KV secrets engine, "secret" by default
+	Mount string
+	// KV secrets engine version, 1 or 2. Version 2 is the default.
+	Version int
+	// Namespace of Vault Enterprise
+	Namespace string
+	// Token to authenticate with. If empty, it is read from TokenFile,
+	// then taken from VAULT_TOKEN.
+	Token, TokenFile string
+	// RoleID and SecretID of AppRole authentication, used instead of the
+	// token if set. AppRoleMount is "approle" by default.
+	RoleID, SecretID, AppRoleMount string
+	// TLS client certificate and CA certificate files
+	CertFile, KeyFile, CACertFile string
+	// Interval of polling for secret changes, DefaultInterval if zero
+	Interval time.Duration
+	// Timeout of a request, DefaultTimeout if zero
+	Timeout time.Duration
+	// Client to make requests with. Built from TLS settings if not
+	// specified.
+	Client *http.Client
+}
+
+// Loader implements loader.Loader for Vault KV secret. The secret data is
+// decoded following the rules of libkv loader.
+type Loader struct {
+	path    string
+	cfg     Config
+	changes chan struct{}
+	wake    chan struct{}
+	stop    context.CancelFunc
+	done    chan struct{}
+	err     error
+
+	lock          sync.Mutex
+	token         string
+	tokenRenewAt  time.Time
+	tokenExpireAt time.Time
+	data          map[string]interface{}
+	version       int
+	sum           [sha256.Size]byte
+	lease         lease
+	onError       func(error)
+}
+
+type lease struct {
+	id        string
+	renewable bool
+	duration  time.Duration
+	renewAt   time.Time
+}
+
+var _ loader.Loader = (*Loader)(nil)
+
+// New creates Loader for the secret path and starts polling it. The config
+// may be nil.
+func New(path string, cfg *Config) (res *Loader) {
+	res = &Loader{
+		path:    strings.Trim(path, "/"),
+		changes: make(chan struct{}),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	if cfg != nil {
+		res.cfg = *cfg
+	}
+	res.stop = func() {}
+	if res.err = res.init(); res.err != nil {
+		close(res.changes)
+		close(res.done)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	res.stop = cancel
+	go res.poll(ctx)
+	return
+}
+
+func (l *Loader) init() error {
+	cfg := &l.cfg
+	if cfg.Address == "" {
+		cfg.Address = os.Getenv(AddrEnv)
+	}
+	if cfg.Address == "" {
+		cfg.Address = DefaultAddress
+	}
+	cfg.Address = strings.TrimSuffix(cfg.Address, "/")
+	if cfg.Mount == "" {
+		cfg.Mount = "secret"
+	}
+	cfg.Mount = strings.Trim(cfg.Mount, "/")
+	if cfg.Version == 0 {
+		cfg.Version = 2
+	}
+	if cfg.Version != 1 && cfg.Version != 2 {
+		return loader.Errors.Errorf("unsupported KV secrets engine version %d", cfg.Version)
+	}
+	if cfg.AppRoleMount == "" {
+		cfg.AppRoleMount = "approle"
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.Client == nil {
+		tlsConfig, err := tlsutil.Config(cfg.CertFile, cfg.KeyFile, cfg.CACertFile)
+		if err != nil {
+			return err
+		}
+		cfg.Client = &http.Client{Timeout: cfg.Timeout}
+		if tlsConfig != nil {
+			cfg.Client.Transport = &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			}
+		}
+	}
+	switch {
+	case cfg.RoleID != "":
+	case cfg.Token != "":
+		l.token = cfg.Token
+	case cfg.TokenFile != "":
+		data, err := ioutil.ReadFile(cfg.TokenFile)
+		if err != nil {
+			return loader.Errors.Wrap(err, "reading Vault token")
+		}
+		l.token = strings.TrimSpace(string(data))
+	default:
+		l.token = os.Getenv(TokenEnv)
+	}
+	return nil
+}
+
+// Close stops polling and closes changes channel
+func (l *Loader) Close() error {
+	l.stop()
+	<-l.done
+	return nil
+}
+
+// Changes provides source of config change events. The event is emitted when
+// the secret version changes, or the secret lease is about to expire and
+// can't be renewed.
+func (l *Loader) Changes() <-chan struct{} {
+	return l.changes
+}
+
+// Version returns the secret version read by the last Load. It is always 0
+// for KV version 1.
+func (l *Loader) Version() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.version
+}
+
+// WithPollError sets the handler of polling errors and returns the Loader.
+// The handler is called from the polling goroutine when the secret can't be
+// read or the token can't be renewed.
+func (l *Loader) WithPollError(handler func(error)) *Loader {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.onError = handler
+	return l
+}
+
+// Load reads the secret and decodes it into the target object
+func (l *Loader) Load(dest interface{}) error {
+	if l.err != nil {
+		return l.err
+	}
+	if _, err := l.read(context.Background()); err != nil {
+		return err
+	}
+	// The decoding modifies the tree, so it gets a copy not shared with
+	// concurrent loads
+	l.lock.Lock()
+	data := copyTree(l.data)
+	l.lock.Unlock()
+	return libkv.Decode(data, dest)
+}
+
+func copyTree(tree interface{}) interface{} {
+	switch v := tree.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k := range v {
+			res[k] = copyTree(v[k])
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i := range v {
+			res[i] = copyTree(v[i])
+		}
+		return res
+	}
+	return tree
+}
+
+type response struct {
+	LeaseID       string          `json:"lease_id"`
+	LeaseDuration int             `json:"lease_duration"`
+	Renewable     bool            `json:"renewable"`
+	Data          json.RawMessage `json:"data"`
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+	status int
+}
+
+// request calls Vault API with the token, if any. The error response is
+// returned along with the error.
+func (l *Loader) request(ctx context.Context, method, path string, body interface{}, token string) (*response, error) {
+	var reqBody []byte
+	if body != nil {
+		var err error
+		if reqBody, err = json.Marshal(body); err != nil {
+			return nil, loader.Errors.Wrap(err, "encoding request")
+		}
+	}
+	req, err := http.NewRequest(method, l.cfg.Address+"/v1/"+path, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, loader.Errors.Wrap(err, "creating request")
+	}
+	ctx, cancel := context.WithTimeout(ctx, l.cfg.Timeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if l.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", l.cfg.Namespace)
+	}
+	resp, err := l.cfg.Client.Do(req)
+	if err != nil {
+		return nil, loader.Errors.Wrapf(err, "requesting %q", path)
+	}
+	defer resp.Body.Close()
+	res := &response{status: resp.StatusCode}
+	if resp.StatusCode != http.StatusNoContent {
+		if err = json.NewDecoder(resp.Body).Decode(res); err != nil && resp.StatusCode < 300 {
+			return nil, loader.Errors.Wrapf(err, "decoding %q", path)
+		}
+	}
+	if resp.StatusCode >= 300 {
+		return res, loader.Errors.Errorf("requesting %q: %s: %s", path, resp.Status, strings.Join(res.Errors, "; "))
+	}
+	return res, nil
+}
+
+// authenticate logs in with AppRole, unless there is a token already, which
+// is not about to expire
+func (l *Loader) authenticate(ctx context.Context) (string, error) {
+	l.lock.Lock()
+	token, expireAt := l.token, l.tokenExpireAt
+	l.lock.Unlock()
+	if l.cfg.RoleID == "" || token != "" && (expireAt.IsZero() || time.Now().Before(expireAt)) {
+		return token, nil
+	}
+	resp, err := l.request(ctx, http.MethodPost, "auth/"+l.cfg.AppRoleMount+"/login", map[string]string{
+		"role_id":   l.cfg.RoleID,
+		"secret_id": l.cfg.SecretID,
+	}, "")
+	if err != nil {
+		return "", loader.Errors.Wrap(err, "AppRole login")
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return "", loader.Errors.New("AppRole login: no token returned")
+	}
+	l.setToken(resp.Auth.ClientToken, resp.Auth.LeaseDuration, resp.Auth.Renewable)
+	return resp.Auth.ClientToken, nil
+}
+
+func (l *Loader) setToken(token string, leaseDuration int, renewable bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.token = token
+	l.tokenRenewAt, l.tokenExpireAt = time.Time{}, time.Time{}
+	switch {
+	case leaseDuration <= 0:
+	case renewable:
+		l.tokenRenewAt = renewAt(leaseDuration)
+		l.reschedule()
+	default:
+		// The token can't be renewed, so the login is repeated before it
+		// expires
+		l.tokenExpireAt = renewAt(leaseDuration)
+	}
+}
+
+// reschedule wakes up polling goroutine to take the new renewal time into
+// account
+func (l *Loader) reschedule() {
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// renewAt schedules renewal when two thirds of the lease have passed
+func renewAt(leaseDuration int) time.Time {
+	return time.Now().Add(time.Duration(leaseDuration) * time.Second * 2 / 3)
+}
+
+// renewToken renews the token lease. If that fails, the token is dropped to
+// log in again on the next read.
+func (l *Loader) renewToken(ctx context.Context) {
+	l.lock.Lock()
+	token := l.token
+	l.lock.Unlock()
+	resp, err := l.request(ctx, http.MethodPost, "auth/token/renew-self", nil, token)
+	if err == nil && resp.Auth != nil {
+		l.setToken(token, resp.Auth.LeaseDuration, resp.Auth.Renewable)
+		return
+	}
+	if err == nil {
+		err = loader.Errors.New("no auth returned")
+	}
+	l.pollError(loader.Errors.Wrap(err, "renewing token"))
+	if l.cfg.RoleID != "" {
+		l.setToken("", 0, false)
+	}
+}
+
+// read fetches the secret and reports whether it has changed since the
+// previous read
+func (l *Loader) read(ctx context.Context) (changed bool, err error) {
+	token, err := l.authenticate(ctx)
+	if err != nil {
+		return false, err
+	}
+	path := l.cfg.Mount + "/" + l.path
+	if l.cfg.Version == 2 {
+		path = l.cfg.Mount + "/data/" + l.path
+	}
+	resp, err := l.request(ctx, http.MethodGet, path, nil, token)
+	if err != nil && resp != nil && resp.status == http.StatusForbidden && l.cfg.RoleID != "" {
+		// The token might have been revoked, so the login is retried once
+		l.setToken("", 0, false)
+		if token, err = l.authenticate(ctx); err != nil {
+			return false, err
+		}
+		resp, err = l.request(ctx, http.MethodGet, path, nil, token)
+	}
+	if err != nil {
+		return false, err
+	}
+	var (
+		data    map[string]interface{}
+		version int
+	)
+	if l.cfg.Version == 2 {
+		var v2 struct {
+			Data     map[string]interface{} `json:"data"`
+			Metadata struct {
+				Version int `json:"version"`
+			} `json:"metadata"`
+		}
+		err = json.Unmarshal(resp.Data, &v2)
+		data, version = v2.Data, v2.Metadata.Version
+	} else {
+		err = json.Unmarshal(resp.Data, &data)
+	}
+	if err != nil {
+		return false, loader.Errors.Wrapf(err, "decoding secret %q", path)
+	}
+	sum := sha256.Sum256(resp.Data)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	changed = l.data != nil && (version != l.version || sum != l.sum)
+	l.data, l.version, l.sum = data, version, sum
+	l.lease = lease{
+		id:        resp.LeaseID,
+		renewable: resp.Renewable,
+		duration:  time.Duration(resp.LeaseDuration) * time.Second,
+	}
+	if resp.LeaseID != "" && resp.LeaseDuration > 0 {
+		l.lease.renewAt = renewAt(resp.LeaseDuration)
+		l.reschedule()
+	}
+	return changed, nil
+}
+
+// renewLease renews the secret lease. It reports whether the lease is about
+// to expire, so that the secret has to be read again.
+func (l *Loader) renewLease(ctx context.Context) (expiring bool) {
+	l.lock.Lock()
+	current, token := l.lease, l.token
+	l.lease.renewAt = time.Time{}
+	l.lock.Unlock()
+	if !current.renewable {
+		return true
+	}
+	resp, err := l.request(ctx, http.MethodPut, "sys/leases/renew", map[string]interface{}{
+		"lease_id":  current.id,
+		"increment": int(current.duration / time.Second),
+	}, token)
+	if err != nil || resp.LeaseDuration <= 0 {
+		return true
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.lease.id == current.id {
+		l.lease.renewAt = renewAt(resp.LeaseDuration)
+	}
+	return false
+}
+
+func (l *Loader) poll(ctx context.Context) {
+	defer close(l.done)
+	defer close(l.changes)
+	nextRead := time.Now().Add(l.cfg.Interval)
+	for {
+		next := nextRead
+		l.lock.Lock()
+		tokenRenewAt, leaseRenewAt := l.tokenRenewAt, l.lease.renewAt
+		l.lock.Unlock()
+		for _, t := range []time.Time{tokenRenewAt, leaseRenewAt} {
+			if !t.IsZero() && t.Before(next) {
+				next = t
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-l.wake:
+			continue
+		case <-time.After(time.Until(next)):
+		}
+		now := time.Now()
+		if !tokenRenewAt.IsZero() && !now.Before(tokenRenewAt) {
+			l.renewToken(ctx)
+		}
+		notify := !leaseRenewAt.IsZero() && !now.Before(leaseRenewAt) && l.renewLease(ctx)
+		if !now.Before(nextRead) {
+			changed, err := l.read(ctx)
+			if err != nil && ctx.Err() == nil {
+				l.pollError(err)
+			}
+			notify = notify || err == nil && changed
+			nextRead = now.Add(l.cfg.Interval)
+		}
+		if notify {
+			select {
+			case l.changes <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (l *Loader) pollError(err error) {
+	l.lock.Lock()
+	handler := l.onError
+	l.lock.Unlock()
+	if handler != nil {
+		handler(err)
+	}
+}
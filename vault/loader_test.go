@@ -0,0 +1,296 @@
+package vault_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-mixins/loader/vault"
+)
+
+// fakeVault serves the parts of Vault API used by the loader
+type fakeVault struct {
+	sync.Mutex
+	version  int
+	data     map[string]interface{}
+	lease    map[string]interface{}
+	token    string
+	logins   int
+	renewals map[string]int
+	// nonRenewable makes the login return the token which can't be renewed
+	nonRenewable bool
+	// fail makes the secret reads fail
+	fail bool
+}
+
+func (f *fakeVault) reply(w http.ResponseWriter, res map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+	if r.URL.Path == "/v1/auth/approle/login" {
+		if body["role_id"] != "role" || body["secret_id"] != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			f.reply(w, map[string]interface{}{"errors": []string{"invalid role or secret ID"}})
+			return
+		}
+		f.logins++
+		f.token = "s.token"
+		f.reply(w, map[string]interface{}{"auth": map[string]interface{}{
+			"client_token":   f.token,
+			"lease_duration": 1,
+			"renewable":      !f.nonRenewable,
+		}})
+		return
+	}
+	if f.token == "" || r.Header.Get("X-Vault-Token") != f.token {
+		w.WriteHeader(http.StatusForbidden)
+		f.reply(w, map[string]interface{}{"errors": []string{"permission denied"}})
+		return
+	}
+	if f.fail && r.URL.Path != "/v1/auth/token/renew-self" {
+		w.WriteHeader(http.StatusInternalServerError)
+		f.reply(w, map[string]interface{}{"errors": []string{"internal error"}})
+		return
+	}
+	switch r.URL.Path {
+	case "/v1/auth/token/renew-self":
+		f.renewals["token"]++
+		f.reply(w, map[string]interface{}{"auth": map[string]interface{}{
+			"client_token":   "s.token",
+			"lease_duration": 1,
+			"renewable":      true,
+		}})
+	case "/v1/sys/leases/renew":
+		f.renewals[body["lease_id"].(string)]++
+		f.reply(w, map[string]interface{}{"lease_id": body["lease_id"], "lease_duration": 1, "renewable": true})
+	case "/v1/secret/data/app":
+		f.reply(w, map[string]interface{}{"data": map[string]interface{}{
+			"data":     f.data,
+			"metadata": map[string]interface{}{"version": f.version},
+		}})
+	case "/v1/kv/app":
+		res := map[string]interface{}{"data": f.data}
+		for k, v := range f.lease {
+			res[k] = v
+		}
+		f.reply(w, res)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeVault) count(name string) int {
+	f.Lock()
+	defer f.Unlock()
+	return f.renewals[name]
+}
+
+func (f *fakeVault) countLogins() int {
+	f.Lock()
+	defer f.Unlock()
+	return f.logins
+}
+
+type testConfig struct {
+	Password string
+	DB       struct {
+		Port int
+	}
+}
+
+func waitChange(t *testing.T, l *vault.Loader) {
+	select {
+	case <-l.Changes():
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for change")
+	}
+}
+
+func TestLoader_AppRole(t *testing.T) {
+	fake := &fakeVault{
+		version:  1,
+		data:     map[string]interface{}{"password": "x", "db": map[string]interface{}{"port": 5432}},
+		renewals: make(map[string]int),
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	failing := vault.New("app", &vault.Config{Address: srv.URL, RoleID: "role", SecretID: "wrong"})
+	if err := failing.Load(&testConfig{}); err == nil {
+		t.Error("expecting login error")
+	}
+	failing.Close()
+
+	l := vault.New("/app/", &vault.Config{
+		Address:  srv.URL,
+		RoleID:   "role",
+		SecretID: "secret",
+		Interval: 50 * time.Millisecond,
+	})
+	defer l.Close()
+	var dest testConfig
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dest.Password != "x" || dest.DB.Port != 5432 || l.Version() != 1 {
+		t.Errorf("invalid result %+v at version %d", dest, l.Version())
+	}
+	fake.Lock()
+	fake.version, fake.data["password"] = 2, "y"
+	fake.Unlock()
+	waitChange(t, l)
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dest.Password != "y" || l.Version() != 2 {
+		t.Errorf("invalid result %+v at version %d", dest, l.Version())
+	}
+	time.Sleep(time.Second)
+	if n := fake.count("token"); n == 0 {
+		t.Error("token must be renewed")
+	}
+}
+
+func TestLoader_Lease(t *testing.T) {
+	fake := &fakeVault{
+		token:    "s.token",
+		data:     map[string]interface{}{"password": "x"},
+		lease:    map[string]interface{}{"lease_id": "kv/app/1", "lease_duration": 1, "renewable": true},
+		renewals: make(map[string]int),
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	os.Setenv(vault.TokenEnv, "s.token")
+	defer os.Unsetenv(vault.TokenEnv)
+	l := vault.New("app", &vault.Config{
+		Address:  srv.URL,
+		Mount:    "kv",
+		Version:  1,
+		Interval: time.Hour,
+	})
+	defer l.Close()
+	var dest testConfig
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if dest.Password != "x" {
+		t.Errorf("invalid result %+v", dest)
+	}
+	time.Sleep(time.Second)
+	if n := fake.count("kv/app/1"); n == 0 {
+		t.Error("lease must be renewed")
+	}
+	// Non-renewable lease makes the secret to be loaded again
+	fake.Lock()
+	fake.lease = map[string]interface{}{"lease_id": "kv/app/2", "lease_duration": 1}
+	fake.Unlock()
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	waitChange(t, l)
+}
+
+func TestLoader_TokenExpiry(t *testing.T) {
+	fake := &fakeVault{
+		version:      1,
+		data:         map[string]interface{}{"password": "x"},
+		renewals:     make(map[string]int),
+		nonRenewable: true,
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	l := vault.New("app", &vault.Config{
+		Address:  srv.URL,
+		RoleID:   "role",
+		SecretID: "secret",
+		Interval: 50 * time.Millisecond,
+	})
+	defer l.Close()
+	errs := make(chan error, 100)
+	l.WithPollError(func(err error) { errs <- err })
+	var dest testConfig
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// The login is repeated before the token expires
+	time.Sleep(time.Second)
+	if n := fake.countLogins(); n < 2 {
+		t.Errorf("expecting login again, got %d logins", n)
+	}
+	// Revoked token is replaced by login
+	fake.Lock()
+	fake.token = ""
+	fake.Unlock()
+	logins := fake.countLogins()
+	if err := l.Load(&dest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if n := fake.countLogins(); n == logins {
+		t.Error("expecting login again")
+	}
+	if len(errs) != 0 {
+		t.Errorf("unexpected error: %+v", <-errs)
+	}
+	fake.Lock()
+	fake.fail = true
+	fake.Unlock()
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "internal error") {
+			t.Errorf("invalid error: %+v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("expecting poll error")
+	}
+}
+
+func TestLoader_Timeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+	l := vault.New("app", &vault.Config{
+		Address: srv.URL,
+		Token:   "s.token",
+		Timeout: 50 * time.Millisecond,
+	})
+	defer l.Close()
+	start := time.Now()
+	if err := l.Load(&testConfig{}); err == nil {
+		t.Error("expecting timeout error")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("request must time out, took %v", d)
+	}
+}
+
+func TestNew_Failure(t *testing.T) {
+	l := vault.New("app", &vault.Config{Version: 3})
+	defer l.Close()
+	if err := l.Load(&struct{}{}); err == nil {
+		t.Error("error expected")
+	}
+	select {
+	case _, ok := <-l.Changes():
+		if ok {
+			t.Error("unexpected change")
+		}
+	case <-time.After(time.Second):
+		t.Error("changes must be closed")
+	}
+}